@@ -3,7 +3,10 @@ package api
 import (
 	"errors"
 	"net/http"
+	"time"
 
+	"github.com/chadhao/logit/modules/location/model"
+	userApi "github.com/chadhao/logit/modules/user/api"
 	"github.com/chadhao/logit/modules/user/constant"
 	"github.com/chadhao/logit/utils"
 	"github.com/labstack/echo/v4"
@@ -34,22 +37,93 @@ func addDrivingLoc(c echo.Context) error {
 	return c.JSON(http.StatusCreated, drivingLoc)
 }
 
-// getDrivingLocs 获取行驶信息
-func getDrivingLocs(c echo.Context) error {
+// addDrivingLocs 批量添加离线缓存的行驶信息
+func addDrivingLocs(c echo.Context) error {
 
 	roles := utils.RolesAssert(c.Get("roles"))
-	if !roles.Is(constant.ROLE_SUPER) {
-		return errors.New("not super")
+	if !roles.Is(constant.ROLE_DRIVER) {
+		return errors.New("not driver")
 	}
+	userID, _ := c.Get("user").(primitive.ObjectID)
+
+	req := new(reqAddDrivingLocs)
+	if err := req.bind(c.Request()); err != nil {
+		return err
+	}
+
+	result, err := req.saveDrivingLocs(userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// getDrivingLocs 获取行驶信息, format为geojson时返回行驶路线的GeoJSON
+func getDrivingLocs(c echo.Context) error {
 
 	req := new(reqDrivingLocs)
 	if err := c.Bind(req); err != nil {
 		return err
 	}
+	driverID, err := primitive.ObjectIDFromHex(req.DriverID)
+	if err != nil {
+		return err
+	}
+	uid, _ := c.Get("user").(primitive.ObjectID)
 
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_SUPER), roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_TO_SUPER), roles.Is(constant.ROLE_TO_ADMIN):
+		if !operatorDriver(uid, driverID) {
+			return errors.New("no authorization")
+		}
+	case roles.Is(constant.ROLE_DRIVER):
+		if driverID != uid {
+			return errors.New("no authorization")
+		}
+	default:
+		return errors.New("not allowed")
+	}
+
+	if req.Format == "geojson" {
+		track, err := req.getTrack()
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, track)
+	}
 	drivingLocs, err := req.getDrivingLocs()
 	if err != nil {
 		return err
 	}
 	return c.JSON(http.StatusOK, drivingLocs)
 }
+
+// operatorDriver 用户是否属于司机所在的运营商
+func operatorDriver(uid, driverID primitive.ObjectID) bool {
+	driver, err := userApi.FindDriver(driverID)
+	if err != nil {
+		return false
+	}
+	for _, v := range driver.TransportOperatorIds {
+		if userApi.IsOperatorUser(v, uid) {
+			return true
+		}
+	}
+	return false
+}
+
+// runCompaction 立即压缩旧的行驶位置
+func runCompaction(c echo.Context) error {
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_SUPER) && !roles.Is(constant.ROLE_ADMIN) {
+		return errors.New("not allowed")
+	}
+
+	results, err := model.RunCompaction(time.Now())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, results)
+}
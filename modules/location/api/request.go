@@ -1,10 +1,15 @@
 package api
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"errors"
+	"io"
+	"net/http"
 	"time"
 
 	"github.com/chadhao/logit/modules/location/model"
+	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	valid "github.com/asaskevich/govalidator"
@@ -31,6 +36,10 @@ type reqDrivingLocs struct {
 	DriverID string    `json:"driverID" query:"driverID" valid:"required"`
 	From     time.Time `json:"from" query:"from" valid:"required"`
 	To       time.Time `json:"to" query:"to" valid:"optional"`
+	Format   string    `json:"format" query:"format" valid:"in(json|geojson),optional"`
+	// Resolution 简化路线的容差(米), Interval 每个位置的最短间隔(秒), 均为0时返回全部位置
+	Resolution float64 `json:"resolution" query:"resolution" valid:"-"`
+	Interval   int     `json:"interval" query:"interval" valid:"-"`
 }
 
 func (req *reqDrivingLocs) valid() error {
@@ -43,6 +52,9 @@ func (req *reqDrivingLocs) valid() error {
 	if req.From.After(req.To) {
 		return errors.New("times order is wrong")
 	}
+	if req.Resolution < 0 || req.Interval < 0 {
+		return errors.New("resolution and interval cannot be negative")
+	}
 	return nil
 }
 
@@ -58,5 +70,66 @@ func (req *reqDrivingLocs) getDrivingLocs() ([]model.DrivingLoc, error) {
 	if err != nil {
 		return nil, err
 	}
-	return drivingLocs, err
+	if req.Resolution > 0 || req.Interval > 0 {
+		drivingLocs = model.Simplify(drivingLocs, req.Resolution, time.Duration(req.Interval)*time.Second)
+	}
+	return drivingLocs, nil
+}
+
+// getTrack 获取司机行驶路线的GeoJSON
+func (req *reqDrivingLocs) getTrack() (*model.GeoFeatureCollection, error) {
+	drivingLocs, err := req.getDrivingLocs()
+	if err != nil {
+		return nil, err
+	}
+	driverID, _ := primitive.ObjectIDFromHex(req.DriverID)
+	return model.Track(driverID, drivingLocs), nil
+}
+
+// maxBatchBody 批量上传解压后的最大字节数
+const maxBatchBody = 32 << 20
+
+// reqAddDrivingLocs 批量添加行驶信息请求结构, 请求体可使用gzip压缩
+type reqAddDrivingLocs struct {
+	Points []reqAddDrivingLoc `json:"points"`
+}
+
+// bind 读取请求体, Content-Encoding为gzip时先解压
+func (req *reqAddDrivingLocs) bind(r *http.Request) error {
+	var body io.Reader = r.Body
+	if r.Header.Get(echo.HeaderContentEncoding) == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		body = gz
+	}
+	// 多读一个字节以判断是否超出限制
+	lr := &io.LimitedReader{R: body, N: maxBatchBody + 1}
+	if err := json.NewDecoder(lr).Decode(req); err != nil {
+		if lr.N <= 0 {
+			return errors.New("request body too large")
+		}
+		return err
+	}
+	return nil
+}
+
+// saveDrivingLocs 批量保存行驶信息
+func (req *reqAddDrivingLocs) saveDrivingLocs(driverID primitive.ObjectID) (*model.BatchResult, error) {
+	if len(req.Points) == 0 {
+		return nil, errors.New("points cannot be empty")
+	}
+	if len(req.Points) > model.MaxBatchSize {
+		return nil, errors.New("too many points")
+	}
+	drivingLocs := make([]model.DrivingLoc, len(req.Points))
+	for i, v := range req.Points {
+		drivingLocs[i] = model.DrivingLoc{
+			Coors:     v.Coors,
+			CreatedAt: v.CreatedAt,
+		}
+	}
+	return model.SaveDrivingLocs(driverID, drivingLocs)
 }
@@ -15,10 +15,22 @@ func LoadRoutes(r router.Router) {
 		Handler: addDrivingLoc,
 		Roles:   []int{constant.ROLE_DRIVER},
 	})
+	r.Add(&router.Route{
+		Path:    "/location/batch",
+		Method:  http.MethodPost,
+		Handler: addDrivingLocs,
+		Roles:   []int{constant.ROLE_DRIVER},
+	})
 	r.Add(&router.Route{
 		Path:    "/location",
 		Method:  http.MethodGet,
 		Handler: getDrivingLocs,
-		Roles:   []int{constant.ROLE_SUPER},
+		Roles:   []int{constant.ROLE_SUPER, constant.ROLE_ADMIN, constant.ROLE_TO_SUPER, constant.ROLE_TO_ADMIN, constant.ROLE_DRIVER},
+	})
+	r.Add(&router.Route{
+		Path:    "/location/compaction/run",
+		Method:  http.MethodPost,
+		Handler: runCompaction,
+		Roles:   []int{constant.ROLE_SUPER, constant.ROLE_ADMIN},
 	})
 }
@@ -1,6 +1,8 @@
 package location
 
 import (
+	"time"
+
 	"github.com/chadhao/logit/config"
 	"github.com/chadhao/logit/modules/location/api"
 	"github.com/chadhao/logit/modules/location/model"
@@ -13,6 +15,7 @@ func InitModule(r router.Router, c config.Config) error {
 		return err
 	}
 	api.LoadRoutes(r)
+	model.StartCompaction(24 * time.Hour)
 	return nil
 }
 
@@ -0,0 +1,226 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// MaxBatchSize 单次批量上传的最大位置数
+	MaxBatchSize = 20000
+	// batchChunk 每次批量写入的位置数
+	batchChunk = 1000
+	// maxClockSkew 允许的设备时钟超前时长
+	maxClockSkew = 5 * time.Minute
+)
+
+// errCompacted 位置所在的时间段已压缩
+var errCompacted = errors.New("driving location has been compacted")
+
+// drivingLocIndex 司机和时间唯一索引的名称
+const drivingLocIndex = "driverID_1_createdAt_1"
+
+// RejectedLoc 被拒绝的位置, Index为其在上传列表中的序号
+type RejectedLoc struct {
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+}
+
+// BatchResult 批量上传结果, Duplicates为与本批次或已有数据时间相同而跳过的位置数
+type BatchResult struct {
+	Received   int           `json:"received"`
+	Accepted   int           `json:"accepted"`
+	Duplicates int           `json:"duplicates"`
+	Rejected   []RejectedLoc `json:"rejected"`
+}
+
+// validLoc 检查单个位置, 返回拒绝原因
+func validLoc(dLoc *DrivingLoc, now time.Time) string {
+	switch {
+	case dLoc.Coors.EmptyCoors():
+		return "coors cannot be null"
+	case dLoc.Coors.Lat < -90 || dLoc.Coors.Lat > 90 || dLoc.Coors.Lng < -180 || dLoc.Coors.Lng > 180:
+		return "coors out of range"
+	case dLoc.CreatedAt.IsZero():
+		return "createdAt is required"
+	case dLoc.CreatedAt.After(now.Add(maxClockSkew)):
+		return "cannot add future time"
+	}
+	return ""
+}
+
+// SaveDrivingLocs 批量保存司机离线缓存的行驶位置, 按司机和时间去重, 数据库时间精确到毫秒
+func SaveDrivingLocs(driverID primitive.ObjectID, drivingLocs []DrivingLoc) (*BatchResult, error) {
+	result := &BatchResult{Received: len(drivingLocs), Rejected: []RejectedLoc{}}
+	now := time.Now()
+	until, err := compactedUntil(driverID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. 检查并去除本批次内时间相同的位置, 已压缩时间段内的位置可能已被压缩删除, 不再保存
+	seen := make(map[int64]bool)
+	accepted := []DrivingLoc{}
+	var from, to time.Time
+	for i := range drivingLocs {
+		dLoc := drivingLocs[i]
+		if reason := validLoc(&dLoc, now); reason != "" {
+			result.Rejected = append(result.Rejected, RejectedLoc{Index: i, Reason: reason})
+			continue
+		}
+		dLoc.CreatedAt = dLoc.CreatedAt.Truncate(time.Millisecond)
+		if dLoc.CreatedAt.Before(until) {
+			result.Rejected = append(result.Rejected, RejectedLoc{Index: i, Reason: errCompacted.Error()})
+			continue
+		}
+		key := dLoc.CreatedAt.UnixNano()
+		if seen[key] {
+			result.Duplicates++
+			continue
+		}
+		seen[key] = true
+		if from.IsZero() || dLoc.CreatedAt.Before(from) {
+			from = dLoc.CreatedAt
+		}
+		if dLoc.CreatedAt.After(to) {
+			to = dLoc.CreatedAt
+		}
+		dLoc.ID = primitive.NewObjectID()
+		dLoc.DriverID = driverID
+		dLoc.Point = dLoc.Coors.point()
+		accepted = append(accepted, dLoc)
+	}
+	if len(accepted) == 0 {
+		return result, nil
+	}
+
+	// 2. 去除数据库中已有的位置, 重新上传同一批数据时不会重复保存
+	existing, err := drivingLocTimes(driverID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	docs := []interface{}{}
+	for _, v := range accepted {
+		if existing[v.CreatedAt.UnixNano()] {
+			result.Duplicates++
+			continue
+		}
+		docs = append(docs, v)
+	}
+
+	// 3. 分批无序写入
+	for len(docs) > 0 {
+		n := batchChunk
+		if len(docs) < n {
+			n = len(docs)
+		}
+		res, err := drivingLocCol.InsertMany(context.TODO(), docs[:n], options.InsertMany().SetOrdered(false))
+		if bwe, ok := err.(mongo.BulkWriteException); ok {
+			for _, we := range bwe.WriteErrors {
+				if we.Code != duplicateKeyCode {
+					return nil, err
+				}
+			}
+			result.Accepted += n - len(bwe.WriteErrors)
+			result.Duplicates += len(bwe.WriteErrors)
+		} else if err != nil {
+			return nil, err
+		} else {
+			result.Accepted += len(res.InsertedIDs)
+		}
+		docs = docs[n:]
+	}
+	return result, nil
+}
+
+// drivingLocTimes 获取司机在[from, to]时间段内已有位置的时间
+func drivingLocTimes(driverID primitive.ObjectID, from, to time.Time) (map[int64]bool, error) {
+	query := bson.M{
+		"driverID":  driverID,
+		"createdAt": bson.M{"$gte": from, "$lte": to},
+	}
+	opts := options.Find().SetProjection(bson.M{"createdAt": 1})
+	cursor, err := drivingLocCol.Find(context.TODO(), query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	times := make(map[int64]bool)
+	for cursor.Next(context.TODO()) {
+		v := struct {
+			CreatedAt time.Time `bson:"createdAt"`
+		}{}
+		if err = cursor.Decode(&v); err != nil {
+			return nil, err
+		}
+		times[v.CreatedAt.Truncate(time.Millisecond).UnixNano()] = true
+	}
+	return times, cursor.Err()
+}
+
+// uniqueDrivingLocIndex 创建司机和时间的唯一索引, 已有非唯一的旧索引时先删除重复位置和旧索引
+func uniqueDrivingLocIndex() error {
+	cursor, err := drivingLocCol.Indexes().List(context.TODO())
+	if err != nil {
+		return err
+	}
+	specs := []bson.M{}
+	if err = cursor.All(context.TODO(), &specs); err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		if spec["name"] != drivingLocIndex || spec["unique"] == true {
+			continue
+		}
+		if err = removeDuplicateDrivingLocs(); err != nil {
+			return err
+		}
+		if _, err = drivingLocCol.Indexes().DropOne(context.TODO(), drivingLocIndex); err != nil {
+			return err
+		}
+	}
+	_, err = drivingLocCol.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "driverID", Value: 1}, {Key: "createdAt", Value: 1}},
+			Options: options.Index().SetName(drivingLocIndex).SetUnique(true),
+		},
+	)
+	return err
+}
+
+// removeDuplicateDrivingLocs 删除司机和时间相同的重复位置, 保留最早写入的一个
+func removeDuplicateDrivingLocs() error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "driverID", Value: "$driverID"}, {Key: "createdAt", Value: "$createdAt"}}},
+			{Key: "ids", Value: bson.M{"$push": "$_id"}},
+			{Key: "count", Value: bson.M{"$sum": 1}},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+	cursor, err := drivingLocCol.Aggregate(context.TODO(), pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.TODO())
+	for cursor.Next(context.TODO()) {
+		v := struct {
+			IDs []primitive.ObjectID `bson:"ids"`
+		}{}
+		if err = cursor.Decode(&v); err != nil {
+			return err
+		}
+		if err = DeleteDrivingLocs(v.IDs[1:]); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -5,17 +5,19 @@ import (
 	"fmt"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"googlemaps.github.io/maps"
 )
 
 var (
-	config        map[string]string
-	mgoClient     *mongo.Client
-	db            *mongo.Database
-	drivingLocCol *mongo.Collection
-	mapClient     *maps.Client
+	config           map[string]string
+	mgoClient        *mongo.Client
+	db               *mongo.Database
+	drivingLocCol    *mongo.Collection
+	compactionCol    *mongo.Collection
+	geocoder         Geocoder
+	compactionTicker *time.Ticker
 )
 
 func dbConnect() (err error) {
@@ -33,6 +35,18 @@ func dbConnect() (err error) {
 	}
 	db = mgoClient.Database(database)
 	drivingLocCol = db.Collection("driving_location")
+	compactionCol = db.Collection("driving_location_compaction")
+	if err = uniqueDrivingLocIndex(); err != nil {
+		return
+	}
+	if _, err = drivingLocCol.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys: bson.M{"point": "2dsphere"},
+		},
+	); err != nil {
+		return
+	}
 	return
 }
 
@@ -42,20 +56,21 @@ func New(c map[string]string) (err error) {
 	if err = dbConnect(); err != nil {
 		return
 	}
-	if err = mapConnect(); err != nil {
+	if geocoder, err = newGeocoder(); err != nil {
+		return
+	}
+	if err = migratePoints(); err != nil {
 		return
 	}
 	return nil
 }
 
-func mapConnect() (err error) {
-	mapClient, err = maps.NewClient(maps.WithAPIKey(config["location.gmap.apikey"]))
-	return
-}
-
 // Close 关闭
 func Close() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	if compactionTicker != nil {
+		compactionTicker.Stop()
+	}
 	mgoClient.Disconnect(ctx)
 }
@@ -0,0 +1,191 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// defaultCompactionDays 默认压缩多少天以前的行驶位置
+	defaultCompactionDays = 30
+	// defaultCompactionTolerance 默认压缩时Douglas–Peucker算法的容差(米)
+	defaultCompactionTolerance = 10.0
+	// compactionWindow 每次压缩的时间段
+	compactionWindow = 24 * time.Hour
+)
+
+// CompactionResult 一名司机的压缩结果
+type CompactionResult struct {
+	DriverID primitive.ObjectID `json:"driverID"`
+	Before   int                `json:"before"`
+	After    int                `json:"after"`
+}
+
+// Compaction 司机行驶位置已压缩到的时间, 早于该时间的位置不再保存, 以免重新上传已压缩删除的位置
+type Compaction struct {
+	DriverID primitive.ObjectID `bson:"_id" json:"driverID"`
+	Until    time.Time          `bson:"until" json:"until"`
+}
+
+var compactionMu sync.Mutex
+
+// compactionConfig 读取压缩配置, 未配置时使用默认值
+func compactionConfig() (days int, tolerance float64, interval time.Duration) {
+	days, err := strconv.Atoi(config["location.compaction.days"])
+	if err != nil || days <= 0 {
+		days = defaultCompactionDays
+	}
+	tolerance, err = strconv.ParseFloat(config["location.compaction.tolerance"], 64)
+	if err != nil || tolerance <= 0 {
+		tolerance = defaultCompactionTolerance
+	}
+	if seconds, err := strconv.Atoi(config["location.compaction.interval"]); err == nil && seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+	return
+}
+
+// StartCompaction 按interval定时压缩旧的行驶位置
+func StartCompaction(interval time.Duration) {
+	compactionTicker = time.NewTicker(interval)
+	go func() {
+		for range compactionTicker.C {
+			if _, err := RunCompaction(time.Now()); err != nil {
+				log.Println("location compaction:", err)
+			}
+		}
+	}()
+}
+
+// RunCompaction 将配置天数以前尚未压缩的行驶位置替换为简化后的路线, 保留起点、终点及停留位置
+func RunCompaction(now time.Time) ([]CompactionResult, error) {
+	compactionMu.Lock()
+	defer compactionMu.Unlock()
+
+	days, tolerance, interval := compactionConfig()
+	cutoff := now.AddDate(0, 0, -days)
+	filter := bson.M{"createdAt": bson.M{"$lt": cutoff}, "compacted": bson.M{"$ne": true}}
+	values, err := drivingLocCol.Distinct(context.TODO(), "driverID", filter)
+	if err != nil {
+		return nil, err
+	}
+	results := []CompactionResult{}
+	for _, v := range values {
+		driverID, ok := v.(primitive.ObjectID)
+		if !ok {
+			continue
+		}
+		result, err := compactDriver(driverID, cutoff, tolerance, interval)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+// compactDriver 按时间段逐段压缩司机cutoff之前的行驶位置
+func compactDriver(driverID primitive.ObjectID, cutoff time.Time, tolerance float64, interval time.Duration) (*CompactionResult, error) {
+	result := &CompactionResult{DriverID: driverID}
+	for {
+		first := new(DrivingLoc)
+		opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+		filter := bson.M{"driverID": driverID, "createdAt": bson.M{"$lt": cutoff}, "compacted": bson.M{"$ne": true}}
+		err := drivingLocCol.FindOne(context.TODO(), filter, opts).Decode(first)
+		if err == mongo.ErrNoDocuments {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		to := first.CreatedAt.Add(compactionWindow)
+		if to.After(cutoff) {
+			to = cutoff
+		}
+		before, after, err := compactWindow(driverID, first.CreatedAt, to, tolerance, interval)
+		if err != nil {
+			return nil, err
+		}
+		result.Before += before
+		result.After += after
+	}
+}
+
+// compactWindow 压缩[from, to)时间段内的行驶位置. 先记录压缩时间, 之后上传的该时间段位置会被拒绝;
+// 再删除舍弃的位置并标记保留的位置, 中途失败时剩余的位置仍未标记, 下次压缩时重新简化
+func compactWindow(driverID primitive.ObjectID, from, to time.Time, tolerance float64, interval time.Duration) (int, int, error) {
+	if err := setCompactedUntil(driverID, to); err != nil {
+		return 0, 0, err
+	}
+	query := bson.M{
+		"driverID":  driverID,
+		"createdAt": bson.M{"$gte": from, "$lt": to},
+		"compacted": bson.M{"$ne": true},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := drivingLocCol.Find(context.TODO(), query, opts)
+	if err != nil {
+		return 0, 0, err
+	}
+	drivingLocs := []DrivingLoc{}
+	if err = cursor.All(context.TODO(), &drivingLocs); err != nil {
+		return 0, 0, err
+	}
+	if len(drivingLocs) == 0 {
+		return 0, 0, errors.New("no driving locations to compact")
+	}
+
+	kept := Simplify(drivingLocs, tolerance, interval)
+	keep := make(map[primitive.ObjectID]bool)
+	keptIDs := make([]primitive.ObjectID, len(kept))
+	for i, v := range kept {
+		keep[v.ID] = true
+		keptIDs[i] = v.ID
+	}
+	droppedIDs := []primitive.ObjectID{}
+	for _, v := range drivingLocs {
+		if !keep[v.ID] {
+			droppedIDs = append(droppedIDs, v.ID)
+		}
+	}
+	if err = DeleteDrivingLocs(droppedIDs); err != nil {
+		return 0, 0, err
+	}
+	update := bson.M{"$set": bson.M{"compacted": true}}
+	if _, err = drivingLocCol.UpdateMany(context.TODO(), bson.M{"_id": bson.M{"$in": keptIDs}}, update); err != nil {
+		return 0, 0, err
+	}
+	return len(drivingLocs), len(kept), nil
+}
+
+// setCompactedUntil 记录司机行驶位置已压缩到的时间, 只会向后移动
+func setCompactedUntil(driverID primitive.ObjectID, until time.Time) error {
+	update := bson.M{"$max": bson.M{"until": until}}
+	_, err := compactionCol.UpdateOne(context.TODO(), bson.M{"_id": driverID}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// compactedUntil 获取司机行驶位置已压缩到的时间, 没有压缩记录时以最后一个已压缩的位置为准
+func compactedUntil(driverID primitive.ObjectID) (time.Time, error) {
+	c := new(Compaction)
+	err := compactionCol.FindOne(context.TODO(), bson.M{"_id": driverID}).Decode(c)
+	if err != mongo.ErrNoDocuments {
+		return c.Until, err
+	}
+	last := new(DrivingLoc)
+	opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	err = drivingLocCol.FindOne(context.TODO(), bson.M{"driverID": driverID, "compacted": true}, opts).Decode(last)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	return last.CreatedAt, err
+}
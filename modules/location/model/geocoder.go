@@ -0,0 +1,312 @@
+package model
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"googlemaps.github.io/maps"
+)
+
+// errNoMatch 找不到匹配的地址或坐标
+var errNoMatch = errors.New("can not find any match address")
+
+// defaultGazetteerRadius 离线地名库反向查找时允许的最大距离(米)
+const defaultGazetteerRadius = 1000.0
+
+// earthRadius 地球平均半径(米)
+const earthRadius = 6371000.0
+
+// Geocoder 地址与坐标互相转换
+type Geocoder interface {
+	Geocode(addr Address) (Coors, error)
+	ReverseGeocode(coors Coors) (Address, error)
+}
+
+// SetGeocoder 替换当前使用的Geocoder
+func SetGeocoder(g Geocoder) {
+	geocoder = g
+}
+
+// normalize 规范化地址, 忽略大小写、多余空白及末尾标点
+func (addr Address) normalize() string {
+	s := strings.ToLower(strings.Join(strings.Fields(string(addr)), " "))
+	return strings.TrimRight(s, " ,.")
+}
+
+// round 坐标保留5位小数, 约1米精度
+func (coors Coors) round() Coors {
+	return Coors{
+		Lat: math.Round(coors.Lat*1e5) / 1e5,
+		Lng: math.Round(coors.Lng*1e5) / 1e5,
+	}
+}
+
+// distance 两个坐标之间的球面距离(米)
+func (coors Coors) distance(o Coors) float64 {
+	lat1, lat2 := coors.Lat*math.Pi/180, o.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (o.Lng - coors.Lng) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// googleGeocoder 使用Google Maps Geocoding API
+type googleGeocoder struct {
+	client *maps.Client
+}
+
+// Geocode 通过地址获取坐标
+func (g *googleGeocoder) Geocode(addr Address) (Coors, error) {
+	resp, err := g.client.Geocode(context.TODO(), &maps.GeocodingRequest{Address: string(addr)})
+	if err != nil {
+		return Coors{}, err
+	}
+	if len(resp) == 0 {
+		return Coors{}, errNoMatch
+	}
+	return Coors{Lat: resp[0].Geometry.Location.Lat, Lng: resp[0].Geometry.Location.Lng}, nil
+}
+
+// ReverseGeocode 通过坐标获取地址
+func (g *googleGeocoder) ReverseGeocode(coors Coors) (Address, error) {
+	resp, err := g.client.Geocode(context.TODO(), &maps.GeocodingRequest{
+		LatLng: &maps.LatLng{Lat: coors.Lat, Lng: coors.Lng},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(resp) == 0 {
+		return "", errNoMatch
+	}
+	return Address(resp[0].FormattedAddress), nil
+}
+
+// gazetteerEntry 离线地名库中的一个地点
+type gazetteerEntry struct {
+	Address Address
+	Coors   Coors
+}
+
+// gazetteerGeocoder 基于本地文件的离线地名库, 不依赖外部服务
+type gazetteerGeocoder struct {
+	entries []gazetteerEntry
+	index   map[string]int
+	radius  float64
+}
+
+// newGazetteerGeocoder 从CSV读取地名库, 每行为"地址,纬度,经度"
+func newGazetteerGeocoder(r io.Reader, radius float64) (*gazetteerGeocoder, error) {
+	g := &gazetteerGeocoder{index: make(map[string]int), radius: radius}
+	cr := csv.NewReader(bufio.NewReader(r))
+	cr.FieldsPerRecord = 3
+	cr.Comment = '#'
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		lat, err := strconv.ParseFloat(strings.TrimSpace(row[1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		lng, err := strconv.ParseFloat(strings.TrimSpace(row[2]), 64)
+		if err != nil {
+			return nil, err
+		}
+		e := gazetteerEntry{Address: Address(strings.TrimSpace(row[0])), Coors: Coors{Lat: lat, Lng: lng}}
+		if _, ok := g.index[e.Address.normalize()]; !ok {
+			g.index[e.Address.normalize()] = len(g.entries)
+		}
+		g.entries = append(g.entries, e)
+	}
+	return g, nil
+}
+
+// Geocode 按规范化后的地址精确查找
+func (g *gazetteerGeocoder) Geocode(addr Address) (Coors, error) {
+	i, ok := g.index[addr.normalize()]
+	if !ok {
+		return Coors{}, errNoMatch
+	}
+	return g.entries[i].Coors, nil
+}
+
+// ReverseGeocode 查找radius范围内最近的地点
+func (g *gazetteerGeocoder) ReverseGeocode(coors Coors) (Address, error) {
+	best, bestDist := -1, g.radius
+	for i, e := range g.entries {
+		if d := coors.distance(e.Coors); d <= bestDist {
+			best, bestDist = i, d
+		}
+	}
+	if best < 0 {
+		return "", errNoMatch
+	}
+	return g.entries[best].Address, nil
+}
+
+// geocodeResult 缓存的转换结果
+type geocodeResult struct {
+	Key       string    `bson:"_id" json:"-"`
+	Address   Address   `bson:"address" json:"address"`
+	Coors     Coors     `bson:"coors" json:"coors"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// geocodeCache 转换结果的缓存存储
+type geocodeCache interface {
+	get(key string) (*geocodeResult, error)
+	set(result *geocodeResult) error
+}
+
+// mongoCache 缓存在数据库中
+type mongoCache struct {
+	col *mongo.Collection
+}
+
+func (mc *mongoCache) get(key string) (*geocodeResult, error) {
+	result := new(geocodeResult)
+	err := mc.col.FindOne(context.TODO(), bson.M{"_id": key}).Decode(result)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return result, err
+}
+
+func (mc *mongoCache) set(result *geocodeResult) error {
+	_, err := mc.col.ReplaceOne(context.TODO(), bson.M{"_id": result.Key}, result, options.Replace().SetUpsert(true))
+	return err
+}
+
+// redisCache 缓存在Redis中
+type redisCache struct {
+	client *redis.Client
+	prefix string
+}
+
+func (rc *redisCache) get(key string) (*geocodeResult, error) {
+	b, err := rc.client.Get(rc.prefix + key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := &geocodeResult{Key: key}
+	return result, json.Unmarshal(b, result)
+}
+
+func (rc *redisCache) set(result *geocodeResult) error {
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return rc.client.Set(rc.prefix+result.Key, b, 0).Err()
+}
+
+// cachedGeocoder 缓存下层Geocoder的结果, 正向按规范化地址, 反向按约1米精度的坐标
+type cachedGeocoder struct {
+	next  Geocoder
+	cache geocodeCache
+}
+
+func addrKey(addr Address) string {
+	return "addr:" + addr.normalize()
+}
+
+func coorsKey(coors Coors) string {
+	c := coors.round()
+	return fmt.Sprintf("coors:%.5f,%.5f", c.Lat, c.Lng)
+}
+
+// Geocode 优先从缓存获取
+func (cg *cachedGeocoder) Geocode(addr Address) (Coors, error) {
+	if result, err := cg.cache.get(addrKey(addr)); err == nil && result != nil {
+		return result.Coors, nil
+	}
+	coors, err := cg.next.Geocode(addr)
+	if err != nil {
+		return coors, err
+	}
+	cg.store(addr, coors, addrKey(addr))
+	return coors, nil
+}
+
+// ReverseGeocode 优先从缓存获取, 只缓存坐标的结果, 查询的车辆位置不能作为该地址的正向结果
+func (cg *cachedGeocoder) ReverseGeocode(coors Coors) (Address, error) {
+	if result, err := cg.cache.get(coorsKey(coors)); err == nil && result != nil {
+		return result.Address, nil
+	}
+	addr, err := cg.next.ReverseGeocode(coors)
+	if err != nil {
+		return addr, err
+	}
+	cg.store(addr, coors, coorsKey(coors))
+	return addr, nil
+}
+
+// store 写入缓存, 缓存失败不影响转换结果
+func (cg *cachedGeocoder) store(addr Address, coors Coors, keys ...string) {
+	for _, key := range keys {
+		cg.cache.set(&geocodeResult{Key: key, Address: addr, Coors: coors, CreatedAt: time.Now()})
+	}
+}
+
+// newGeocoder 按配置创建Geocoder, 默认使用Google
+func newGeocoder() (Geocoder, error) {
+	var g Geocoder
+	switch config["location.geocoder.provider"] {
+	case "", "google":
+		client, err := maps.NewClient(maps.WithAPIKey(config["location.gmap.apikey"]))
+		if err != nil {
+			return nil, err
+		}
+		g = &googleGeocoder{client: client}
+	case "gazetteer":
+		f, err := os.Open(config["location.geocoder.gazetteer.file"])
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		radius := defaultGazetteerRadius
+		if v, err := strconv.ParseFloat(config["location.geocoder.gazetteer.radius"], 64); err == nil && v > 0 {
+			radius = v
+		}
+		if g, err = newGazetteerGeocoder(f, radius); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("unsupported geocoder provider")
+	}
+
+	switch config["location.geocoder.cache"] {
+	case "":
+		return g, nil
+	case "mongo":
+		return &cachedGeocoder{next: g, cache: &mongoCache{col: db.Collection("geocode_cache")}}, nil
+	case "redis":
+		client := redis.NewClient(&redis.Options{
+			Addr:     config["location.redis.address"],
+			Password: config["location.redis.password"],
+		})
+		return &cachedGeocoder{next: g, cache: &redisCache{client: client, prefix: "geocode:"}}, nil
+	}
+	return nil, errors.New("unsupported geocoder cache")
+}
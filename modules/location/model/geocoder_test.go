@@ -0,0 +1,183 @@
+package model
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+const testGazetteer = `# address,lat,lng
+Auckland Airport, -37.0082, 174.7850
+"1 Queen Street, Auckland",-36.8442,174.7676
+Wellington Railway Station,-41.2789,174.7806
+`
+
+// memoryCache 测试用的内存缓存
+type memoryCache map[string]*geocodeResult
+
+func (mc memoryCache) get(key string) (*geocodeResult, error) {
+	return mc[key], nil
+}
+
+func (mc memoryCache) set(result *geocodeResult) error {
+	mc[result.Key] = result
+	return nil
+}
+
+// countingGeocoder 记录调用次数的Geocoder
+type countingGeocoder struct {
+	next     Geocoder
+	forward  int
+	backward int
+}
+
+func (g *countingGeocoder) Geocode(addr Address) (Coors, error) {
+	g.forward++
+	return g.next.Geocode(addr)
+}
+
+func (g *countingGeocoder) ReverseGeocode(coors Coors) (Address, error) {
+	g.backward++
+	return g.next.ReverseGeocode(coors)
+}
+
+func newTestGazetteer(t *testing.T) *gazetteerGeocoder {
+	g, err := newGazetteerGeocoder(strings.NewReader(testGazetteer), defaultGazetteerRadius)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestGazetteerGeocode(t *testing.T) {
+	g := newTestGazetteer(t)
+	tests := []struct {
+		addr  Address
+		coors Coors
+		err   error
+	}{
+		{"Auckland Airport", Coors{Lat: -37.0082, Lng: 174.7850}, nil},
+		{"  auckland   AIRPORT. ", Coors{Lat: -37.0082, Lng: 174.7850}, nil},
+		{"1 Queen Street, Auckland", Coors{Lat: -36.8442, Lng: 174.7676}, nil},
+		{"Christchurch Airport", Coors{}, errNoMatch},
+	}
+	for _, tt := range tests {
+		coors, err := g.Geocode(tt.addr)
+		if err != tt.err || coors != tt.coors {
+			t.Errorf("Geocode(%q) = %v, %v; want %v, %v", tt.addr, coors, err, tt.coors, tt.err)
+		}
+	}
+}
+
+func TestGazetteerReverseGeocode(t *testing.T) {
+	g := newTestGazetteer(t)
+	tests := []struct {
+		coors Coors
+		addr  Address
+		err   error
+	}{
+		{Coors{Lat: -37.0082, Lng: 174.7850}, "Auckland Airport", nil},
+		{Coors{Lat: -36.8450, Lng: 174.7680}, "1 Queen Street, Auckland", nil},
+		{Coors{Lat: -41.2600, Lng: 174.7806}, "", errNoMatch},
+		{Coors{Lat: -43.4894, Lng: 172.5320}, "", errNoMatch},
+	}
+	for _, tt := range tests {
+		addr, err := g.ReverseGeocode(tt.coors)
+		if err != tt.err || addr != tt.addr {
+			t.Errorf("ReverseGeocode(%v) = %q, %v; want %q, %v", tt.coors, addr, err, tt.addr, tt.err)
+		}
+	}
+}
+
+func TestGazetteerInvalid(t *testing.T) {
+	tests := []string{
+		"Auckland Airport,-37.0082\n",
+		"Auckland Airport,south,174.7850\n",
+		"Auckland Airport,-37.0082,east\n",
+	}
+	for _, tt := range tests {
+		if _, err := newGazetteerGeocoder(strings.NewReader(tt), defaultGazetteerRadius); err == nil {
+			t.Errorf("newGazetteerGeocoder(%q) should fail", tt)
+		}
+	}
+}
+
+func TestCachedGeocoder(t *testing.T) {
+	next := &countingGeocoder{next: newTestGazetteer(t)}
+	cg := &cachedGeocoder{next: next, cache: memoryCache{}}
+	noMatch := func(addr Address) func() error {
+		return func() error {
+			if _, err := cg.Geocode(addr); err != errNoMatch {
+				return err
+			}
+			return nil
+		}
+	}
+
+	tests := []struct {
+		name     string
+		call     func() error
+		forward  int
+		backward int
+	}{
+		{"geocode miss", func() error { _, err := cg.Geocode("Auckland Airport"); return err }, 1, 0},
+		{"geocode hit", func() error { _, err := cg.Geocode("auckland airport."); return err }, 1, 0},
+		{"reverse miss", func() error { _, err := cg.ReverseGeocode(Coors{Lat: -36.8450, Lng: 174.7680}); return err }, 1, 1},
+		{"reverse hit", func() error { _, err := cg.ReverseGeocode(Coors{Lat: -36.845001, Lng: 174.768001}); return err }, 1, 1},
+		{"geocode after reverse", func() error { _, err := cg.Geocode("1 Queen Street, Auckland"); return err }, 2, 1},
+		{"no match is not cached", noMatch("Nowhere"), 3, 1},
+		{"no match again", noMatch("Nowhere"), 4, 1},
+	}
+	for _, tt := range tests {
+		if err := tt.call(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if next.forward != tt.forward || next.backward != tt.backward {
+			t.Errorf("%s: calls = %d, %d; want %d, %d", tt.name, next.forward, next.backward, tt.forward, tt.backward)
+		}
+	}
+
+	// 反向查询的车辆位置不能成为地址的正向结果
+	coors, err := cg.Geocode("1 Queen Street, Auckland")
+	if err != nil || coors != (Coors{Lat: -36.8442, Lng: 174.7676}) {
+		t.Errorf("Geocode after reverse = %v, %v", coors, err)
+	}
+}
+
+func TestSetGeocoder(t *testing.T) {
+	old := geocoder
+	defer SetGeocoder(old)
+	SetGeocoder(newTestGazetteer(t))
+
+	coors, err := Address("Wellington Railway Station").GetCoorsFromAddr()
+	if err != nil || coors != (Coors{Lat: -41.2789, Lng: 174.7806}) {
+		t.Errorf("GetCoorsFromAddr = %v, %v", coors, err)
+	}
+	addr, err := coors.GetAddrFromCoors()
+	if err != nil || addr != "Wellington Railway Station" {
+		t.Errorf("GetAddrFromCoors = %q, %v", addr, err)
+	}
+}
+
+func TestCoorsDistance(t *testing.T) {
+	// 沿经线或赤道每度的距离
+	degree := math.Pi / 180 * earthRadius
+	tests := []struct {
+		name string
+		a, b Coors
+		want float64
+	}{
+		{"same point", Coors{Lat: -36.8442, Lng: 174.7676}, Coors{Lat: -36.8442, Lng: 174.7676}, 0},
+		{"along meridian", Coors{Lat: -37, Lng: 175}, Coors{Lat: -38, Lng: 175}, degree},
+		{"along equator", Coors{Lat: 0, Lng: 179.5}, Coors{Lat: 0, Lng: -179.5}, degree},
+		{"at 60 degrees", Coors{Lat: 60, Lng: 10}, Coors{Lat: 60, Lng: 10.01}, degree * 0.01 / 2},
+		{"auckland to wellington", Coors{Lat: -37.0082, Lng: 174.7850}, Coors{Lat: -41.2789, Lng: 174.7806}, 474880},
+	}
+	for _, tt := range tests {
+		for _, d := range []float64{tt.a.distance(tt.b), tt.b.distance(tt.a)} {
+			if math.Abs(d-tt.want) > tt.want*1e-4+0.01 {
+				t.Errorf("%s: distance = %v, want %v", tt.name, d, tt.want)
+			}
+		}
+	}
+}
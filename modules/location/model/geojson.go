@@ -0,0 +1,108 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GeoPoint GeoJSON点, 坐标顺序为[经度, 纬度], 用于2dsphere索引
+type GeoPoint struct {
+	Type        string     `bson:"type" json:"type"`
+	Coordinates [2]float64 `bson:"coordinates" json:"coordinates"`
+}
+
+// GeoGeometry GeoJSON几何对象
+type GeoGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// GeoFeature GeoJSON要素
+type GeoFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoGeometry            `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// GeoFeatureCollection GeoJSON要素集合, 地图前端可直接渲染
+type GeoFeatureCollection struct {
+	Type     string       `json:"type"`
+	Features []GeoFeature `json:"features"`
+}
+
+// point 转换为GeoJSON点
+func (coors Coors) point() *GeoPoint {
+	return &GeoPoint{Type: "Point", Coordinates: [2]float64{coors.Lng, coors.Lat}}
+}
+
+// lngLat GeoJSON坐标
+func (coors Coors) lngLat() []float64 {
+	return []float64{coors.Lng, coors.Lat}
+}
+
+// Track 将按时间排序的行驶位置转换为GeoJSON, 包含行驶路线及起点、终点,
+// 路线的coordTimes属性为每个坐标对应的时间
+func Track(driverID primitive.ObjectID, drivingLocs []DrivingLoc) *GeoFeatureCollection {
+	fc := &GeoFeatureCollection{Type: "FeatureCollection", Features: []GeoFeature{}}
+	if len(drivingLocs) == 0 {
+		return fc
+	}
+	first, last := drivingLocs[0], drivingLocs[len(drivingLocs)-1]
+	coordinates := make([][]float64, len(drivingLocs))
+	times := make([]time.Time, len(drivingLocs))
+	for i, v := range drivingLocs {
+		coordinates[i] = v.Coors.lngLat()
+		times[i] = v.CreatedAt
+	}
+	// LineString至少需要两个坐标
+	if len(coordinates) > 1 {
+		fc.Features = append(fc.Features, GeoFeature{
+			Type:     "Feature",
+			Geometry: GeoGeometry{Type: "LineString", Coordinates: coordinates},
+			Properties: map[string]interface{}{
+				"driverID":   driverID,
+				"from":       first.CreatedAt,
+				"to":         last.CreatedAt,
+				"coordTimes": times,
+			},
+		})
+	}
+	for _, v := range []struct {
+		name string
+		loc  DrivingLoc
+	}{{"start", first}, {"end", last}} {
+		fc.Features = append(fc.Features, GeoFeature{
+			Type:     "Feature",
+			Geometry: GeoGeometry{Type: "Point", Coordinates: v.loc.Coors.lngLat()},
+			Properties: map[string]interface{}{
+				"driverID": driverID,
+				"name":     v.name,
+				"time":     v.loc.CreatedAt,
+			},
+		})
+	}
+	return fc
+}
+
+// migratePoints 为缺少GeoJSON点的旧行驶位置补充point
+func migratePoints() error {
+	cursor, err := drivingLocCol.Find(context.TODO(), bson.M{"point": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.TODO())
+	for cursor.Next(context.TODO()) {
+		dLoc := new(DrivingLoc)
+		if err = cursor.Decode(dLoc); err != nil {
+			return err
+		}
+		update := bson.M{"$set": bson.M{"point": dLoc.Coors.point()}}
+		if _, err = drivingLocCol.UpdateOne(context.TODO(), bson.M{"_id": dLoc.ID}, update); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
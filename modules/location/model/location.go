@@ -8,9 +8,13 @@ import (
 	valid "github.com/asaskevich/govalidator"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"googlemaps.github.io/maps"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// duplicateKeyCode 数据库唯一索引冲突错误码
+const duplicateKeyCode = 11000
+
 type (
 	// Coors represents a location on the Earth.
 	Coors struct {
@@ -24,6 +28,8 @@ type (
 		DriverID  primitive.ObjectID `bson:"driverID" json:"driverID" valid:"required"`
 		CreatedAt time.Time          `bson:"createdAt" json:"createdAt" valid:"required"`
 		Coors     Coors              `bson:"coors" json:"coors" valid:"required"`
+		Point     *GeoPoint          `bson:"point,omitempty" json:"-" valid:"-"`
+		Compacted bool               `bson:"compacted,omitempty" json:"compacted,omitempty" valid:"-"`
 	}
 
 	// Address 位置信息
@@ -35,31 +41,35 @@ func (dLoc *DrivingLoc) Save() error {
 	if dLoc.Coors.EmptyCoors() {
 		return errors.New("coors cannot be null")
 	}
+	if dLoc.ID.IsZero() {
+		dLoc.ID = primitive.NewObjectID()
+	}
 	if dLoc.CreatedAt.IsZero() {
 		dLoc.CreatedAt = time.Now()
+	} else {
+		until, err := compactedUntil(dLoc.DriverID)
+		if err != nil {
+			return err
+		}
+		if dLoc.CreatedAt.Before(until) {
+			return errCompacted
+		}
 	}
+	dLoc.Point = dLoc.Coors.point()
 	if _, err := valid.ValidateStruct(dLoc); err != nil {
 		return err
 	}
 	_, err := drivingLocCol.InsertOne(context.TODO(), dLoc)
+	// 重复提交同一时间的位置时视为已保存
+	if we, ok := err.(mongo.WriteException); ok && len(we.WriteErrors) > 0 && we.WriteErrors[0].Code == duplicateKeyCode {
+		return nil
+	}
 	return err
 }
 
 // GetCoorsFromAddr 通过位置获取坐标信息
-func (addr Address) GetCoorsFromAddr() (coors Coors, err error) {
-	req := &maps.GeocodingRequest{
-		Address: string(addr),
-	}
-	resp, err := mapClient.Geocode(context.TODO(), req)
-	if err != nil {
-		return
-	}
-	if len(resp) == 0 {
-		err = errors.New("can not find any match address")
-		return
-	}
-	coors = Coors{Lat: resp[0].Geometry.Location.Lat, Lng: resp[0].Geometry.Location.Lng}
-	return
+func (addr Address) GetCoorsFromAddr() (Coors, error) {
+	return geocoder.Geocode(addr)
 }
 
 // EmptyCoors 判断coors是否为空
@@ -68,31 +78,60 @@ func (coors *Coors) EmptyCoors() bool {
 }
 
 // GetAddrFromCoors 通过坐标信息获取位置
-func (coors Coors) GetAddrFromCoors() (addr Address, err error) {
-	req := &maps.GeocodingRequest{
-		LatLng: &maps.LatLng{Lat: coors.Lat, Lng: coors.Lng},
+func (coors Coors) GetAddrFromCoors() (Address, error) {
+	return geocoder.ReverseGeocode(coors)
+}
+
+// GetDrivingLocs 通过driverID和指定时间段返回司机行驶位置信息, 按时间升序排列
+func GetDrivingLocs(driverID primitive.ObjectID, from, to time.Time) ([]DrivingLoc, error) {
+	drivingLocs := []DrivingLoc{}
+	query := bson.M{
+		"driverID":  driverID,
+		"createdAt": bson.M{"$gte": from, "$lte": to},
 	}
-	resp, err := mapClient.Geocode(context.TODO(), req)
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := drivingLocCol.Find(context.TODO(), query, opts)
 	if err != nil {
-		return
+		return nil, err
 	}
-	if len(resp) == 0 {
-		err = errors.New("can not find any match address")
-		return
+	if err = cursor.All(context.TODO(), &drivingLocs); err != nil {
+		return nil, err
 	}
-	addr = Address(resp[0].FormattedAddress)
-	return
+	return drivingLocs, nil
 }
 
-// GetDrivingLocs 通过driverID和指定时间段返回司机行驶位置信息
-func GetDrivingLocs(driverID primitive.ObjectID, from, to time.Time) ([]DrivingLoc, error) {
+// GetDrivingLocDriverIDs 获取在before之前有行驶位置信息的司机
+func GetDrivingLocDriverIDs(before time.Time) ([]primitive.ObjectID, error) {
+	values, err := drivingLocCol.Distinct(context.TODO(), "driverID", bson.M{"createdAt": bson.M{"$lt": before}})
+	if err != nil {
+		return nil, err
+	}
+	ids := []primitive.ObjectID{}
+	for _, v := range values {
+		if id, ok := v.(primitive.ObjectID); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// GetFirstDrivingLoc 获取司机最早的行驶位置信息
+func GetFirstDrivingLoc(driverID primitive.ObjectID) (*DrivingLoc, error) {
+	drivingLoc := new(DrivingLoc)
+	opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	err := drivingLocCol.FindOne(context.TODO(), bson.M{"driverID": driverID}, opts).Decode(drivingLoc)
+	return drivingLoc, err
+}
+
+// FindDrivingLocs 获取司机在[from, to)时间段内的行驶位置信息, 按时间升序排列
+func FindDrivingLocs(driverID primitive.ObjectID, from, to time.Time) ([]DrivingLoc, error) {
 	drivingLocs := []DrivingLoc{}
 	query := bson.M{
-		"driverID": driverID,
-		"gte":      bson.M{"createdAt": from},
-		"lte":      bson.M{"createdAt": to},
+		"driverID":  driverID,
+		"createdAt": bson.M{"$gte": from, "$lt": to},
 	}
-	cursor, err := drivingLocCol.Find(context.TODO(), query)
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := drivingLocCol.Find(context.TODO(), query, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -101,3 +140,44 @@ func GetDrivingLocs(driverID primitive.ObjectID, from, to time.Time) ([]DrivingL
 	}
 	return drivingLocs, nil
 }
+
+// DeleteDrivingLocs 删除行驶位置信息
+func DeleteDrivingLocs(ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := drivingLocCol.DeleteMany(context.TODO(), bson.M{"_id": bson.M{"$in": ids}})
+	return err
+}
+
+// RestoreDrivingLocs 恢复已归档的行驶位置信息, 已存在的跳过
+func RestoreDrivingLocs(drivingLocs []DrivingLoc) error {
+	if len(drivingLocs) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(drivingLocs))
+	for i, v := range drivingLocs {
+		// 早于2dsphere索引归档的数据没有point
+		v.Point = v.Coors.point()
+		docs[i] = v
+	}
+	_, err := drivingLocCol.InsertMany(context.TODO(), docs, options.InsertMany().SetOrdered(false))
+	if bwe, ok := err.(mongo.BulkWriteException); ok {
+		for _, we := range bwe.WriteErrors {
+			if we.Code != duplicateKeyCode {
+				return err
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+// TrackDistance 按时间排序的行驶位置依次相连的球面距离之和(米)
+func TrackDistance(drivingLocs []DrivingLoc) float64 {
+	d := 0.0
+	for i := 1; i < len(drivingLocs); i++ {
+		d += drivingLocs[i-1].Coors.distance(drivingLocs[i].Coors)
+	}
+	return d
+}
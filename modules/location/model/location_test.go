@@ -0,0 +1,28 @@
+package model
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTrackDistance(t *testing.T) {
+	back := straight(3, 500, 0)
+	back = append(back, fix{north: 500, at: 3 * time.Minute})
+	tests := []struct {
+		name  string
+		fixes []fix
+		want  float64
+	}{
+		{"empty", nil, 0},
+		{"single", straight(1, 100, 0), 0},
+		{"straight", straight(11, 100, 0), 1000},
+		{"turned back", back, 1500},
+		{"parked", parked(5, 200, 0), 0},
+	}
+	for _, tt := range tests {
+		if d := TrackDistance(track(tt.fixes...)); math.Abs(d-tt.want) > 0.01 {
+			t.Errorf("%s: TrackDistance = %v, want %v", tt.name, d, tt.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// defaultMovingSpeed 默认视为行驶的最低速度(公里/小时)
+	defaultMovingSpeed = 5.0
+	// defaultDwell 默认视为停留的最短时长, 更短的停留如红绿灯计入行驶
+	defaultDwell = 5 * time.Minute
+)
+
+// Movement 行驶路线中一段连续的行驶或停留, Distance为该段路线长度(米)
+type Movement struct {
+	Moving     bool      `json:"moving"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	StartCoors Coors     `json:"startCoors"`
+	EndCoors   Coors     `json:"endCoors"`
+	Distance   float64   `json:"distance"`
+}
+
+// MovementConfig 读取视为行驶的最低速度(公里/小时)及停留的最短时长(分钟), 未配置时使用默认值
+func MovementConfig() (speed float64, dwell time.Duration) {
+	speed, err := strconv.ParseFloat(config["location.movement.speed"], 64)
+	if err != nil || speed <= 0 {
+		speed = defaultMovingSpeed
+	}
+	dwell = defaultDwell
+	if minutes, err := strconv.Atoi(config["location.movement.dwell"]); err == nil && minutes > 0 {
+		dwell = time.Duration(minutes) * time.Minute
+	}
+	return
+}
+
+// DetectMovements 按相邻位置之间的速度将按时间排序的行驶路线分为行驶和停留,
+// 移动距离不超过stopRadius的行驶视为定位漂移, 短于dwell的停留计入行驶
+func DetectMovements(drivingLocs []DrivingLoc, speed float64, dwell time.Duration) []Movement {
+	movements := []Movement{}
+	for i := 1; i < len(drivingLocs); i++ {
+		a, b := drivingLocs[i-1], drivingLocs[i]
+		d := a.Coors.distance(b.Coors)
+		dt := b.CreatedAt.Sub(a.CreatedAt).Hours()
+		moving := dt > 0 && d/1000/dt >= speed
+		if n := len(movements); n > 0 && (movements[n-1].Moving == moving || dt <= 0) {
+			movements[n-1].End, movements[n-1].EndCoors = b.CreatedAt, b.Coors
+			movements[n-1].Distance += d
+			continue
+		}
+		movements = append(movements, Movement{
+			Moving:     moving,
+			Start:      a.CreatedAt,
+			End:        b.CreatedAt,
+			StartCoors: a.Coors,
+			EndCoors:   b.Coors,
+			Distance:   d,
+		})
+	}
+
+	for i := range movements {
+		if movements[i].Moving && movements[i].Distance <= stopRadius {
+			movements[i].Moving = false
+		}
+	}
+	movements = mergeMovements(movements)
+	if len(movements) > 1 {
+		for i := range movements {
+			if !movements[i].Moving && movements[i].End.Sub(movements[i].Start) < dwell {
+				movements[i].Moving = true
+			}
+		}
+	}
+	return mergeMovements(movements)
+}
+
+// mergeMovements 合并相邻的同类路线段
+func mergeMovements(movements []Movement) []Movement {
+	merged := []Movement{}
+	for _, m := range movements {
+		if n := len(merged); n > 0 && merged[n-1].Moving == m.Moving {
+			merged[n-1].End, merged[n-1].EndCoors = m.End, m.EndCoors
+			merged[n-1].Distance += m.Distance
+			continue
+		}
+		merged = append(merged, m)
+	}
+	return merged
+}
+
+// GetMovements 按配置的速度和停留时长获取司机在时间段内的行驶和停留
+func GetMovements(driverID primitive.ObjectID, from, to time.Time) ([]Movement, error) {
+	drivingLocs, err := GetDrivingLocs(driverID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	speed, dwell := MovementConfig()
+	return DetectMovements(drivingLocs, speed, dwell), nil
+}
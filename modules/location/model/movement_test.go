@@ -0,0 +1,75 @@
+package model
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDetectMovements(t *testing.T) {
+	onward := func(from time.Duration, norths ...float64) []fix {
+		fixes := []fix{}
+		for i, north := range norths {
+			fixes = append(fixes, fix{north: north, at: from + time.Duration(i)*time.Minute})
+		}
+		return fixes
+	}
+	join := func(parts ...[]fix) []fix {
+		fixes := []fix{}
+		for _, p := range parts {
+			fixes = append(fixes, p...)
+		}
+		return fixes
+	}
+
+	tests := []struct {
+		name  string
+		fixes []fix
+		want  []Movement
+	}{
+		{"empty", nil, []Movement{}},
+		{"single", straight(1, 100, 0), []Movement{}},
+		{"drive stop drive", join(straight(6, 1000, 0), parked(10, 5000, 6*time.Minute), onward(16*time.Minute, 6000, 7000, 8000)), []Movement{
+			{Moving: true, Start: trackStart, End: trackStart.Add(5 * time.Minute), Distance: 5000},
+			{Moving: false, Start: trackStart.Add(5 * time.Minute), End: trackStart.Add(15 * time.Minute)},
+			{Moving: true, Start: trackStart.Add(15 * time.Minute), End: trackStart.Add(18 * time.Minute), Distance: 3000},
+		}},
+		{"short stop counts as driving", join(straight(6, 1000, 0), parked(3, 5000, 6*time.Minute), onward(9*time.Minute, 6000, 7000)), []Movement{
+			{Moving: true, Start: trackStart, End: trackStart.Add(10 * time.Minute), Distance: 7000},
+		}},
+		{"drift is not driving", join(parked(5, 0, 0), []fix{{north: 30, at: 4*time.Minute + 5*time.Second}}, parked(5, 30, 5*time.Minute)), []Movement{
+			{Moving: false, Start: trackStart, End: trackStart.Add(9 * time.Minute), Distance: 30},
+		}},
+		{"short stop alone", parked(3, 0, 0), []Movement{
+			{Moving: false, Start: trackStart, End: trackStart.Add(2 * time.Minute)},
+		}},
+		{"slow is not driving", straight(6, 50, 0), []Movement{
+			{Moving: false, Start: trackStart, End: trackStart.Add(5 * time.Minute), Distance: 250},
+		}},
+	}
+	for _, tt := range tests {
+		got := DetectMovements(track(tt.fixes...), defaultMovingSpeed, defaultDwell)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: DetectMovements = %+v, want %+v", tt.name, got, tt.want)
+			continue
+		}
+		for i, m := range got {
+			w := tt.want[i]
+			if m.Moving != w.Moving || !m.Start.Equal(w.Start) || !m.End.Equal(w.End) || math.Abs(m.Distance-w.Distance) > 0.01 {
+				t.Errorf("%s: movement %d = %+v, want %+v", tt.name, i, m, w)
+			}
+		}
+	}
+}
+
+func TestMergeMovements(t *testing.T) {
+	movements := []Movement{
+		{Moving: true, Start: trackStart, End: trackStart.Add(time.Minute), Distance: 100},
+		{Moving: true, Start: trackStart.Add(time.Minute), End: trackStart.Add(2 * time.Minute), Distance: 200},
+		{Moving: false, Start: trackStart.Add(2 * time.Minute), End: trackStart.Add(3 * time.Minute)},
+	}
+	merged := mergeMovements(movements)
+	if len(merged) != 2 || merged[0].Distance != 300 || !merged[0].End.Equal(trackStart.Add(2*time.Minute)) || merged[1].Moving {
+		t.Errorf("mergeMovements = %+v", merged)
+	}
+}
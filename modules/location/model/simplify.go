@@ -0,0 +1,137 @@
+package model
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// stopRadius 停留时位置的最大漂移(米)
+	stopRadius = 50.0
+	// stopDuration 视为停留的最短时长
+	stopDuration = 5 * time.Minute
+	// trackGap 相邻位置间隔超过该时长时视为路线中断
+	trackGap = 10 * time.Minute
+)
+
+// Stop 行驶路线中的一次停留, first和last为停留开始和结束的位置序号
+type Stop struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Coors Coors     `json:"coors"`
+	first int
+	last  int
+}
+
+// findStops 查找按时间排序的位置中在radius米范围内停留至少minDuration的地方
+func findStops(drivingLocs []DrivingLoc, radius float64, minDuration time.Duration) []Stop {
+	stops := []Stop{}
+	for i := 0; i < len(drivingLocs); {
+		j := i
+		for j+1 < len(drivingLocs) && drivingLocs[i].Coors.distance(drivingLocs[j+1].Coors) <= radius {
+			j++
+		}
+		if drivingLocs[j].CreatedAt.Sub(drivingLocs[i].CreatedAt) < minDuration {
+			i++
+			continue
+		}
+		stops = append(stops, Stop{
+			Start: drivingLocs[i].CreatedAt,
+			End:   drivingLocs[j].CreatedAt,
+			Coors: drivingLocs[i].Coors,
+			first: i,
+			last:  j,
+		})
+		i = j + 1
+	}
+	return stops
+}
+
+// segmentDistance 点p到线段ab的距离(米), 在a附近按等距圆柱投影近似为平面
+func segmentDistance(p, a, b Coors) float64 {
+	k := math.Pi / 180 * earthRadius
+	cos := math.Cos(a.Lat * math.Pi / 180)
+	px, py := (p.Lng-a.Lng)*k*cos, (p.Lat-a.Lat)*k
+	bx, by := (b.Lng-a.Lng)*k*cos, (b.Lat-a.Lat)*k
+	l := bx*bx + by*by
+	if l == 0 {
+		return math.Hypot(px, py)
+	}
+	t := math.Max(0, math.Min(1, (px*bx+py*by)/l))
+	return math.Hypot(px-t*bx, py-t*by)
+}
+
+// douglasPeucker 在first至last之间标记偏离超过tolerance米的位置
+func douglasPeucker(drivingLocs []DrivingLoc, first, last int, tolerance float64, keep []bool) {
+	stack := [][2]int{{first, last}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		index, max := -1, tolerance
+		for i := s[0] + 1; i < s[1]; i++ {
+			if d := segmentDistance(drivingLocs[i].Coors, drivingLocs[s[0]].Coors, drivingLocs[s[1]].Coors); d > max {
+				index, max = i, d
+			}
+		}
+		if index < 0 {
+			continue
+		}
+		keep[index] = true
+		stack = append(stack, [2]int{s[0], index}, [2]int{index, s[1]})
+	}
+}
+
+// Simplify 简化按时间排序的行驶路线, 先用Douglas–Peucker算法去除偏离小于tolerance米的位置,
+// 再在每个interval时间段内只保留一个位置; 路线的起点、终点、中断处及停留的起止位置始终保留.
+// tolerance或interval不大于0时跳过对应步骤
+func Simplify(drivingLocs []DrivingLoc, tolerance float64, interval time.Duration) []DrivingLoc {
+	n := len(drivingLocs)
+	if n < 3 {
+		return drivingLocs
+	}
+	required := make([]bool, n)
+	required[0], required[n-1] = true, true
+	for i := 1; i < n; i++ {
+		if drivingLocs[i].CreatedAt.Sub(drivingLocs[i-1].CreatedAt) > trackGap {
+			required[i-1], required[i] = true, true
+		}
+	}
+	for _, s := range findStops(drivingLocs, stopRadius, stopDuration) {
+		required[s.first], required[s.last] = true, true
+	}
+
+	keep := make([]bool, n)
+	copy(keep, required)
+	if tolerance > 0 {
+		// 在相邻的必须保留位置之间分段简化
+		last := 0
+		for i := 1; i < n; i++ {
+			if required[i] {
+				douglasPeucker(drivingLocs, last, i, tolerance, keep)
+				last = i
+			}
+		}
+	} else {
+		for i := range keep {
+			keep[i] = true
+		}
+	}
+
+	simplified := []DrivingLoc{}
+	var bucket time.Time
+	for i, v := range drivingLocs {
+		if !keep[i] {
+			continue
+		}
+		if interval > 0 {
+			// 必须保留的位置同样占用所在的时间段
+			b := v.CreatedAt.Truncate(interval)
+			if b.Equal(bucket) && !required[i] {
+				continue
+			}
+			bucket = b
+		}
+		simplified = append(simplified, v)
+	}
+	return simplified
+}
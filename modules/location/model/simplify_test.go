@@ -0,0 +1,179 @@
+package model
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// trackStart 测试路线的起始时间和位置
+var (
+	trackStart  = time.Date(2020, 3, 2, 8, 0, 0, 0, time.UTC)
+	trackOrigin = Coors{Lat: -37, Lng: 175}
+)
+
+// fix 相对起点向北、向东的距离(米)及时间
+type fix struct {
+	north, east float64
+	at          time.Duration
+}
+
+// offset 起点向北、向东移动给定距离(米)后的坐标
+func offset(north, east float64) Coors {
+	k := math.Pi / 180 * earthRadius
+	return Coors{
+		Lat: trackOrigin.Lat + north/k,
+		Lng: trackOrigin.Lng + east/(k*math.Cos(trackOrigin.Lat*math.Pi/180)),
+	}
+}
+
+func track(fixes ...fix) []DrivingLoc {
+	drivingLocs := []DrivingLoc{}
+	for _, f := range fixes {
+		drivingLocs = append(drivingLocs, DrivingLoc{CreatedAt: trackStart.Add(f.at), Coors: offset(f.north, f.east)})
+	}
+	return drivingLocs
+}
+
+// straight 每分钟向北行驶step米的n个位置
+func straight(n int, step float64, from time.Duration) []fix {
+	fixes := []fix{}
+	for i := 0; i < n; i++ {
+		fixes = append(fixes, fix{north: float64(i) * step, at: from + time.Duration(i)*time.Minute})
+	}
+	return fixes
+}
+
+// parked 在north米处每分钟一个的n个位置
+func parked(n int, north float64, from time.Duration) []fix {
+	fixes := []fix{}
+	for i := 0; i < n; i++ {
+		fixes = append(fixes, fix{north: north, at: from + time.Duration(i)*time.Minute})
+	}
+	return fixes
+}
+
+func fixTimes(drivingLocs []DrivingLoc) []time.Duration {
+	times := []time.Duration{}
+	for _, v := range drivingLocs {
+		times = append(times, v.CreatedAt.Sub(trackStart))
+	}
+	return times
+}
+
+func TestSegmentDistance(t *testing.T) {
+	a, b := offset(0, 0), offset(1000, 0)
+	tests := []struct {
+		name string
+		p    Coors
+		want float64
+	}{
+		{"beside segment", offset(500, 100), 100},
+		{"on segment", offset(300, 0), 0},
+		{"before start", offset(-30, 40), 50},
+		{"after end", offset(1060, 80), 100},
+	}
+	for _, tt := range tests {
+		if d := segmentDistance(tt.p, a, b); math.Abs(d-tt.want) > 0.5 {
+			t.Errorf("%s: segmentDistance = %v, want %v", tt.name, d, tt.want)
+		}
+	}
+	if d := segmentDistance(offset(30, 40), a, a); math.Abs(d-50) > 0.5 {
+		t.Errorf("segmentDistance to point = %v, want 50", d)
+	}
+}
+
+func TestDouglasPeucker(t *testing.T) {
+	locs := track(fix{0, 0, 0}, fix{100, 45, time.Minute}, fix{200, 80, 2 * time.Minute}, fix{300, 35, 3 * time.Minute}, fix{400, 0, 4 * time.Minute})
+	tests := []struct {
+		name      string
+		tolerance float64
+		want      []bool
+	}{
+		{"loose", 100, []bool{false, false, false, false, false}},
+		{"detour", 50, []bool{false, false, true, false, false}},
+		{"tight", 1, []bool{false, true, true, true, false}},
+	}
+	for _, tt := range tests {
+		keep := make([]bool, len(locs))
+		douglasPeucker(locs, 0, len(locs)-1, tt.tolerance, keep)
+		for i := range keep {
+			if keep[i] != tt.want[i] {
+				t.Errorf("%s: keep = %v, want %v", tt.name, keep, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestFindStops(t *testing.T) {
+	withDrift := parked(7, 1000, 5*time.Minute)
+	for i := range withDrift {
+		withDrift[i].east = float64(i%3) * 20
+	}
+
+	tests := []struct {
+		name  string
+		fixes []fix
+		want  [][2]int
+	}{
+		{"moving", straight(10, 500, 0), [][2]int{}},
+		{"parked", append(straight(3, 500, 0), parked(7, 1500, 3*time.Minute)...), [][2]int{{3, 9}}},
+		{"too short", append(straight(3, 500, 0), parked(4, 1500, 3*time.Minute)...), [][2]int{}},
+		{"drift within radius", append(straight(5, 250, 0), withDrift...), [][2]int{{4, 11}}},
+		{"two stops", append(append(parked(6, 0, 0), straight(3, 500, 6*time.Minute)...), parked(6, 3000, 9*time.Minute)...), [][2]int{{0, 6}, {9, 14}}},
+	}
+	for _, tt := range tests {
+		stops := findStops(track(tt.fixes...), stopRadius, stopDuration)
+		if len(stops) != len(tt.want) {
+			t.Errorf("%s: %d stops, want %d", tt.name, len(stops), len(tt.want))
+			continue
+		}
+		for i, s := range stops {
+			if s.first != tt.want[i][0] || s.last != tt.want[i][1] {
+				t.Errorf("%s: stop %d = [%d, %d], want %v", tt.name, i, s.first, s.last, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	detour := straight(9, 100, 0)
+	for i := range detour {
+		detour[i].east = 200 - math.Abs(float64(i-4))*50
+	}
+	gap := append(straight(5, 100, 0), straight(5, 100, 30*time.Minute)...)
+	for i := 5; i < len(gap); i++ {
+		gap[i].north += 500
+	}
+	stop := append(append(straight(3, 100, 0), parked(10, 300, 3*time.Minute)...), fix{400, 0, 13 * time.Minute}, fix{500, 0, 14 * time.Minute})
+
+	tests := []struct {
+		name      string
+		fixes     []fix
+		tolerance float64
+		interval  time.Duration
+		want      []time.Duration
+	}{
+		{"too short", straight(2, 100, 0), 10, 0, []time.Duration{0, time.Minute}},
+		{"straight", straight(10, 100, 0), 10, 0, []time.Duration{0, 9 * time.Minute}},
+		{"detour", detour, 10, 0, []time.Duration{0, 4 * time.Minute, 8 * time.Minute}},
+		{"track gap", gap, 10, 0, []time.Duration{0, 4 * time.Minute, 30 * time.Minute, 34 * time.Minute}},
+		{"stop", stop, 10, 0, []time.Duration{0, 3 * time.Minute, 12 * time.Minute, 14 * time.Minute}},
+		{"interval only", straight(10, 100, 0), 0, 5 * time.Minute, []time.Duration{0, 5 * time.Minute, 9 * time.Minute}},
+		{"unchanged", straight(4, 100, 0), 0, 0, []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute}},
+	}
+	for _, tt := range tests {
+		got := fixTimes(Simplify(track(tt.fixes...), tt.tolerance, tt.interval))
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: Simplify = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: Simplify = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
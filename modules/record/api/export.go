@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	valid "github.com/asaskevich/govalidator"
+	"github.com/chadhao/logit/modules/record/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// headerChainHead 导出时携带各司机哈希链链头的响应头
+const headerChainHead = "X-Chain-Head"
+
+const (
+	// exportCSV CSV导出格式
+	exportCSV = "csv"
+	// exportNDJSON 按行分隔的JSON导出格式
+	exportNDJSON = "ndjson"
+)
+
+// reqExport 批量导出记录请求结构
+type reqExport struct {
+	DriverIDs  []string  `query:"driverID" valid:"required"`
+	From       time.Time `query:"from" valid:"required"`
+	To         time.Time `query:"to" valid:"optional"`
+	Format     string    `query:"format" valid:"in(csv|ndjson),optional"`
+	GetDeleted bool      `query:"getDeleted" valid:"optional"`
+}
+
+func (req *reqExport) valid() error {
+	if _, err := valid.ValidateStruct(req); err != nil {
+		return err
+	}
+	if len(req.DriverIDs) == 0 {
+		return errors.New("driverID is required")
+	}
+	if req.Format == "" {
+		req.Format = exportCSV
+	}
+	if req.To.IsZero() {
+		req.To = time.Now()
+	}
+	if req.From.After(req.To) {
+		return errors.New("times order is wrong")
+	}
+	return nil
+}
+
+func (req *reqExport) driverIDs() ([]primitive.ObjectID, error) {
+	ids := make([]primitive.ObjectID, len(req.DriverIDs))
+	for i, v := range req.DriverIDs {
+		id, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// chainHeads 导出司机的链头, 格式为driverID=seq:hash, 以逗号分隔
+func (req *reqExport) chainHeads() (string, error) {
+	driverIDs, err := req.driverIDs()
+	if err != nil {
+		return "", err
+	}
+	heads := make([]string, len(driverIDs))
+	for i, id := range driverIDs {
+		head, err := model.GetChainHead(id)
+		if err != nil {
+			return "", err
+		}
+		heads[i] = id.Hex() + "=" + head.String()
+	}
+	return strings.Join(heads, ","), nil
+}
+
+func (req *reqExport) contentType() string {
+	if req.Format == exportNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv"
+}
+
+// export 将记录按请求格式流式写入w, 每批写完后调用flush
+func (req *reqExport) export(w io.Writer, flush func()) error {
+	driverIDs, err := req.driverIDs()
+	if err != nil {
+		return err
+	}
+
+	var write func(model.Records, map[primitive.ObjectID]model.DifNotes) error
+	switch req.Format {
+	case exportNDJSON:
+		enc := json.NewEncoder(w)
+		write = func(rs model.Records, notesMap map[primitive.ObjectID]model.DifNotes) error {
+			for _, v := range rs {
+				if err := enc.Encode(&respRecord{Record: v, Notes: notesMap[v.ID]}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	default:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		write = func(rs model.Records, notesMap map[primitive.ObjectID]model.DifNotes) error {
+			for _, v := range rs {
+				row, err := csvRow(&v, notesMap[v.ID])
+				if err != nil {
+					return err
+				}
+				if err = cw.Write(row); err != nil {
+					return err
+				}
+			}
+			cw.Flush()
+			return cw.Error()
+		}
+	}
+
+	return model.ExportRecords(driverIDs, req.From, req.To, req.GetDeleted, func(rs model.Records, notesMap map[primitive.ObjectID]model.DifNotes) error {
+		if err := write(rs, notesMap); err != nil {
+			return err
+		}
+		flush()
+		return nil
+	})
+}
+
+var csvHeader = []string{
+	"id", "driverID", "type", "startTime", "time", "duration",
+	"startAddress", "startLat", "startLng", "endAddress", "endLat", "endLng",
+	"vehicleID", "startDistance", "endDistance", "createdAt", "deletedAt", "notes",
+	"seq", "prevHash", "hash",
+}
+
+// csvRow 将记录转换为CSV行, 笔记以JSON数组形式放在最后一列
+func csvRow(r *model.Record, notes model.DifNotes) ([]string, error) {
+	notesJSON := ""
+	if len(notes) > 0 {
+		b, err := json.Marshal(notes)
+		if err != nil {
+			return nil, err
+		}
+		notesJSON = string(b)
+	}
+	deletedAt := ""
+	if r.DeletedAt != nil {
+		deletedAt = r.DeletedAt.Format(time.RFC3339)
+	}
+	return []string{
+		r.ID.Hex(),
+		r.DriverID.Hex(),
+		string(r.Type),
+		r.Time.Add(-r.Duration).Format(time.RFC3339),
+		r.Time.Format(time.RFC3339),
+		strconv.FormatFloat(r.Duration.Hours(), 'f', 4, 64),
+		string(r.StartLocation.Address),
+		formatFloat(r.StartLocation.Coors.Lat),
+		formatFloat(r.StartLocation.Coors.Lng),
+		string(r.EndLocation.Address),
+		formatFloat(r.EndLocation.Coors.Lat),
+		formatFloat(r.EndLocation.Coors.Lng),
+		r.VehicleID.Hex(),
+		formatOptionalFloat(r.StartMileAge),
+		formatOptionalFloat(r.EndMileAge),
+		r.CreatedAt.Format(time.RFC3339),
+		deletedAt,
+		notesJSON,
+		formatSeq(r.Seq),
+		r.PrevHash,
+		r.Hash,
+	}, nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func formatOptionalFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return formatFloat(*f)
+}
+
+func formatSeq(seq int64) string {
+	if seq == 0 {
+		return ""
+	}
+	return strconv.FormatInt(seq, 10)
+}
@@ -4,12 +4,15 @@ import (
 	"errors"
 	"net/http"
 	"sort"
+	"time"
 
 	"github.com/chadhao/logit/modules/record/model"
+	userApi "github.com/chadhao/logit/modules/user/api"
 	"github.com/chadhao/logit/modules/user/constant"
 	"github.com/chadhao/logit/utils"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // addRecord 添加一条新的记录
@@ -65,6 +68,38 @@ func getLatestRecord(c echo.Context) error {
 	return c.JSON(http.StatusOK, respRecord)
 }
 
+// getStatus 获取当前工时状态
+func getStatus(c echo.Context) error {
+
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_DRIVER) {
+		return errors.New("not driver")
+	}
+
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	now := time.Now()
+	schemes, err := model.GetDriverSchemes(uid, now)
+	if err != nil {
+		return err
+	}
+
+	records := model.Records{}
+	lastRec, err := model.GetLastestRecord(uid)
+	switch err {
+	case nil:
+		records, err = model.GetComplianceRecords(uid, lastRec.Time, lastRec.Time, schemes)
+		if err != nil {
+			return err
+		}
+	case mongo.ErrNoDocuments:
+	default:
+		return err
+	}
+
+	return c.JSON(http.StatusOK, records.Status(now, schemes))
+}
+
 // deleteLatestRecord 删除上一条记录
 func deleteLatestRecord(c echo.Context) error {
 
@@ -87,6 +122,76 @@ func deleteLatestRecord(c echo.Context) error {
 	return c.JSON(http.StatusOK, "success")
 }
 
+// amendRecord 修改记录，原记录保存为历史版本
+func amendRecord(c echo.Context) error {
+
+	req := new(reqAmendRecord)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	var err error
+	if req.ID, err = primitive.ObjectIDFromHex(c.Param("id")); err != nil {
+		return err
+	}
+
+	r, err := model.GetRecord(req.ID)
+	if err != nil {
+		return err
+	}
+
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_DRIVER):
+		if r.DriverID != uid {
+			return errors.New("no authorization")
+		}
+	default:
+		return errors.New("not allowed")
+	}
+
+	resp, err := req.amendRecord(r, uid)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// getRecordHistory 获取记录的历史版本
+func getRecordHistory(c echo.Context) error {
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	r, err := model.GetRecord(id)
+	if err != nil {
+		return err
+	}
+
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_DRIVER):
+		if r.DriverID != uid {
+			return errors.New("no authorization")
+		}
+	default:
+		return errors.New("not allowed")
+	}
+
+	versions, err := model.GetRecordVersions(id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, versions)
+}
+
 // getRecords 获取记录
 func getRecords(c echo.Context) error {
 
@@ -107,6 +212,13 @@ func getRecords(c echo.Context) error {
 		return errors.New("not allowed")
 	}
 
+	if !req.paginated() {
+		records, err := req.getAllRecords()
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, records)
+	}
 	records, err := req.getRecords()
 	if err != nil {
 		return err
@@ -114,6 +226,99 @@ func getRecords(c echo.Context) error {
 	return c.JSON(http.StatusOK, records)
 }
 
+// exportRecords 以CSV或NDJSON格式流式导出记录及笔记
+func exportRecords(c echo.Context) error {
+
+	req := new(reqExport)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	if err := req.valid(); err != nil {
+		return err
+	}
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_DRIVER):
+		if len(req.DriverIDs) != 1 || req.DriverIDs[0] != uid.Hex() {
+			return errors.New("no authorization")
+		}
+	default:
+		return errors.New("not allowed")
+	}
+
+	chainHeads, err := req.chainHeads()
+	if err != nil {
+		return err
+	}
+
+	resp := c.Response()
+	resp.Header().Set(headerChainHead, chainHeads)
+	resp.Header().Set(echo.HeaderContentType, req.contentType())
+	resp.Header().Set(echo.HeaderContentDisposition, `attachment; filename="records.`+req.Format+`"`)
+	resp.WriteHeader(http.StatusOK)
+	return req.export(resp, resp.Flush)
+}
+
+// getCompliance 获取违规情况
+func getCompliance(c echo.Context) error {
+
+	req := new(reqRecords)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_DRIVER):
+		if req.DriverID != uid.Hex() {
+			return errors.New("no authorization")
+		}
+	default:
+		return errors.New("not allowed")
+	}
+
+	violations, err := req.getViolations()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, violations)
+}
+
+// getLogbook 导出PDF格式的日志
+func getLogbook(c echo.Context) error {
+
+	req := new(reqRecords)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_DRIVER):
+		if req.DriverID != uid.Hex() {
+			return errors.New("no authorization")
+		}
+	default:
+		return errors.New("not allowed")
+	}
+
+	lb, err := req.getLogbook()
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="logbook.pdf"`)
+	c.Response().Header().Set(echo.HeaderContentType, "application/pdf")
+	c.Response().WriteHeader(http.StatusOK)
+	return lb.render(c.Response())
+}
+
 // addNote 为记录添加笔记
 func addNote(c echo.Context) error {
 
@@ -150,6 +355,11 @@ func addNote(c echo.Context) error {
 		if err != nil {
 			return err
 		}
+	case model.TRIPNOTE:
+		note, err = req.constructToTripNote()
+		if err != nil {
+			return err
+		}
 	default:
 		return errors.New("no match noteType")
 	}
@@ -161,9 +371,71 @@ func addNote(c echo.Context) error {
 	return c.JSON(http.StatusOK, note)
 }
 
+// getTripNotes 获取行程笔记
+func getTripNotes(c echo.Context) error {
+
+	req := new(reqTripNotes)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_DRIVER):
+		if req.DriverID != uid.Hex() {
+			return errors.New("no authorization")
+		}
+	default:
+		return errors.New("not allowed")
+	}
+
+	tripNotes, err := req.getTripNotes()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, tripNotes)
+}
+
+// updateTripNote 修改行程笔记
+func updateTripNote(c echo.Context) error {
+
+	req := new(reqUpdateTripNote)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	tn, err := model.GetTripNote(id)
+	if err != nil {
+		return err
+	}
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_DRIVER):
+		if tn.DriverID != uid {
+			return errors.New("no authorization")
+		}
+	default:
+		return errors.New("not allowed")
+	}
+
+	if err := req.updateTripNote(tn); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, tn)
+}
+
 // offlineSyncRecords 离线返回在线状态后记录同步
 // 1. 对records按照时间排序，检查相邻两条之间的时间位置是否准确
-// 2. 批量更新入数据库
+// 2. 已同步过的clientID跳过，第一条不合格的记录及之后的记录全部拒绝
+// 3. 批量更新入数据库，返回每条记录的同步结果
 func offlineSyncRecords(c echo.Context) error {
 	roles := utils.RolesAssert(c.Get("roles"))
 	if !roles.Is(constant.ROLE_DRIVER) {
@@ -185,18 +457,666 @@ func offlineSyncRecords(c echo.Context) error {
 		return reqs[a].Time.Before(reqs[b].Time)
 	})
 
+	// 记录依次衔接, 第一条无法构造的记录及之后的记录全部拒绝, 不再提交
+	results := make([]model.SyncResult, l)
 	records := model.Records{}
+	indexes := []int{}
+	rejected := false
 	for i := 0; i < l; i++ {
+		results[i] = model.SyncResult{
+			ClientID: reqs[i].ClientID,
+			Status:   model.SYNCREJECTED,
+			Reason:   "previous record rejected",
+		}
+		if rejected {
+			continue
+		}
 		r, err := reqs[i].constructToSyncRecord(uid)
 		if err != nil {
-			return err
+			results[i].Reason = err.Error()
+			rejected = true
+			continue
 		}
 		records = append(records, *r)
+		indexes = append(indexes, i)
 	}
 
-	if err := records.SyncAdd(); err != nil {
+	synced, err := records.SyncAdd()
+	if err != nil {
 		return err
 	}
-	return c.JSON(http.StatusOK, records)
+	for i, v := range synced {
+		results[indexes[i]] = v
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+// addInspection 司机生成限时检查授权
+func addInspection(c echo.Context) error {
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_DRIVER) {
+		return errors.New("not driver")
+	}
+
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	req := new(reqAddInspection)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	resp, err := req.addInspection(uid)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// revokeInspection 司机提前撤销检查授权
+func revokeInspection(c echo.Context) error {
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_DRIVER) {
+		return errors.New("not driver")
+	}
+
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	i, err := model.GetInspection(id)
+	if err != nil {
+		return err
+	}
+	if i.DriverID != uid {
+		return errors.New("no authorization")
+	}
+
+	if err := i.Revoke(); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, i)
+}
+
+// getInspectionAccesses 司机查看检查授权的访问记录
+func getInspectionAccesses(c echo.Context) error {
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_DRIVER) {
+		return errors.New("not driver")
+	}
+
+	uid, _ := c.Get("user").(primitive.ObjectID)
 
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	i, err := model.GetInspection(id)
+	if err != nil {
+		return err
+	}
+	if i.DriverID != uid {
+		return errors.New("no authorization")
+	}
+
+	accesses, err := model.GetInspectionAccesses(id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, accesses)
+}
+
+// viewInspection 执法人员通过令牌查看只读日志，无需登录
+func viewInspection(c echo.Context) error {
+	i, err := model.GetInspectionByToken(c.Param("token"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	if err := i.Log(c.RealIP(), c.Request().UserAgent()); err != nil {
+		return err
+	}
+
+	lb, err := inspectionLogbook(i)
+	if err != nil {
+		return err
+	}
+	resp := c.Response()
+	if c.QueryParam("format") == "pdf" {
+		resp.Header().Set(echo.HeaderContentType, "application/pdf")
+		resp.WriteHeader(http.StatusOK)
+		return lb.render(resp)
+	}
+	resp.Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	resp.WriteHeader(http.StatusOK)
+	return renderInspection(resp, i, lb)
+}
+
+// addTeamTrip 创建双人驾驶行程
+func addTeamTrip(c echo.Context) error {
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_DRIVER) {
+		return errors.New("not driver")
+	}
+
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	req := new(reqAddTeamTrip)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	tt, err := req.constructToTeamTrip(uid)
+	if err != nil {
+		return err
+	}
+	if err = tt.Add(); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, tt)
+}
+
+// endTeamTrip 结束双人驾驶行程
+func endTeamTrip(c echo.Context) error {
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_DRIVER) {
+		return errors.New("not driver")
+	}
+
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	req := new(reqEndTeamTrip)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	tt, err := model.GetTeamTrip(id)
+	if err != nil {
+		return err
+	}
+	if !tt.HasDriver(uid) {
+		return errors.New("no authorization")
+	}
+
+	if req.EndTime.IsZero() {
+		req.EndTime = time.Now()
+	}
+	if err := tt.End(req.EndTime); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, tt)
+}
+
+// getTeamTrip 获取双人驾驶行程及两名司机的记录
+func getTeamTrip(c echo.Context) error {
+
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	tt, err := model.GetTeamTrip(id)
+	if err != nil {
+		return err
+	}
+
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_DRIVER):
+		if !tt.HasDriver(uid) {
+			return errors.New("no authorization")
+		}
+	default:
+		return errors.New("not allowed")
+	}
+
+	records, err := model.GetTeamTripRecords(id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, &respTeamTrip{TeamTrip: *tt, Records: records})
+}
+
+// getOdometer 获取车辆里程时间线, 包含所有司机的记录
+func getOdometer(c echo.Context) error {
+
+	req := new(reqOdometer)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	vehicleID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_DRIVER):
+		vehicle, err := userApi.FindVehicle(vehicleID)
+		if err != nil {
+			return err
+		}
+		if vehicle.DriverId != uid {
+			return errors.New("no authorization")
+		}
+	default:
+		return errors.New("not allowed")
+	}
+
+	resp, err := req.getOdometer(vehicleID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// getSummaries 获取司机的日汇总或周汇总
+func getSummaries(c echo.Context) error {
+
+	req := new(reqSummaries)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_DRIVER):
+		if req.DriverID != uid.Hex() {
+			return errors.New("no authorization")
+		}
+	default:
+		return errors.New("not allowed")
+	}
+
+	summaries, err := req.getSummaries()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, summaries)
+}
+
+// rebuildSummaries 重新计算司机的汇总, 用于补全历史数据
+func rebuildSummaries(c echo.Context) error {
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_ADMIN) {
+		return errors.New("not admin")
+	}
+
+	req := new(reqSummaries)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	if err := req.rebuildSummaries(); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, "success")
+}
+
+// runRetention 立即执行归档任务
+func runRetention(c echo.Context) error {
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_ADMIN) {
+		return errors.New("not admin")
+	}
+
+	manifests, err := model.RunRetention(time.Now())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, manifests)
+}
+
+// getArchives 获取司机的归档清单
+func getArchives(c echo.Context) error {
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_ADMIN) {
+		return errors.New("not admin")
+	}
+
+	driverID, err := primitive.ObjectIDFromHex(c.QueryParam("driverID"))
+	if err != nil {
+		return err
+	}
+	manifests, err := model.GetArchiveManifests(driverID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, manifests)
+}
+
+// restoreArchives 恢复司机指定时间范围内的归档数据
+func restoreArchives(c echo.Context) error {
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_ADMIN) {
+		return errors.New("not admin")
+	}
+
+	req := new(reqRestoreArchives)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	manifests, err := req.restoreArchives()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, manifests)
+}
+
+// verifyChain 验证司机记录和笔记的哈希链
+func verifyChain(c echo.Context) error {
+
+	driverID, err := primitive.ObjectIDFromHex(c.QueryParam("driverID"))
+	if err != nil {
+		return err
+	}
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_DRIVER):
+		if driverID != uid {
+			return errors.New("no authorization")
+		}
+	default:
+		return errors.New("not allowed")
+	}
+
+	report, err := model.VerifyChain(driverID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// driverOperators 获取司机所属且用户可管理的运营商
+func driverOperators(uid, driverID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	driver, err := userApi.FindDriver(driverID)
+	if err != nil {
+		return nil, err
+	}
+	operatorIDs := []primitive.ObjectID{}
+	for _, v := range driver.TransportOperatorIds {
+		if userApi.IsOperatorUser(v, uid) {
+			operatorIDs = append(operatorIDs, v)
+		}
+	}
+	return operatorIDs, nil
+}
+
+// containsObjectID 判断ids中是否包含id
+func containsObjectID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// addScheme 创建疲劳管理方案, 运营商用户只能为所属运营商创建
+func addScheme(c echo.Context) error {
+
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	req := new(reqAddScheme)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_TO_SUPER), roles.Is(constant.ROLE_TO_ADMIN):
+		if req.TransportOperatorID == nil || !userApi.IsOperatorUser(*req.TransportOperatorID, uid) {
+			return errors.New("no authorization")
+		}
+	default:
+		return errors.New("not allowed")
+	}
+
+	s, err := req.constructToScheme(uid)
+	if err != nil {
+		return err
+	}
+	if err = s.Add(); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, s)
+}
+
+// getSchemes 获取可用的疲劳管理方案, 包括默认方案
+func getSchemes(c echo.Context) error {
+
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	var operatorIDs []primitive.ObjectID
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_TO_SUPER), roles.Is(constant.ROLE_TO_ADMIN):
+		operatorID, err := primitive.ObjectIDFromHex(c.QueryParam("transportOperatorID"))
+		if err != nil {
+			return err
+		}
+		if !userApi.IsOperatorUser(operatorID, uid) {
+			return errors.New("no authorization")
+		}
+		operatorIDs = []primitive.ObjectID{operatorID}
+	case roles.Is(constant.ROLE_DRIVER):
+		driver, err := userApi.FindDriver(uid)
+		if err != nil {
+			return err
+		}
+		operatorIDs = append([]primitive.ObjectID{}, driver.TransportOperatorIds...)
+	default:
+		return errors.New("not allowed")
+	}
+
+	schemes, err := model.GetSchemes(operatorIDs)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, append([]model.Scheme{*model.DefaultScheme}, schemes...))
+}
+
+// assignScheme 为司机分配方案, 运营商用户只能为所属司机分配通用方案或本运营商的方案
+func assignScheme(c echo.Context) error {
+
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	req := new(reqAssignScheme)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_TO_SUPER), roles.Is(constant.ROLE_TO_ADMIN):
+		operatorIDs, err := driverOperators(uid, req.DriverID)
+		if err != nil {
+			return err
+		}
+		if len(operatorIDs) == 0 {
+			return errors.New("no authorization")
+		}
+		s, err := model.GetScheme(req.SchemeID)
+		if err != nil {
+			return err
+		}
+		if s.TransportOperatorID != nil && !containsObjectID(operatorIDs, *s.TransportOperatorID) {
+			return errors.New("no authorization")
+		}
+	default:
+		return errors.New("not allowed")
+	}
+
+	sa, err := req.constructToSchemeAssignment(uid)
+	if err != nil {
+		return err
+	}
+	if err = sa.Add(); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, sa)
+}
+
+// getSchemeAssignments 获取司机的方案分配历史
+func getSchemeAssignments(c echo.Context) error {
+
+	driverID, err := primitive.ObjectIDFromHex(c.QueryParam("driverID"))
+	if err != nil {
+		return err
+	}
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_TO_SUPER), roles.Is(constant.ROLE_TO_ADMIN):
+		operatorIDs, err := driverOperators(uid, driverID)
+		if err != nil {
+			return err
+		}
+		if len(operatorIDs) == 0 {
+			return errors.New("no authorization")
+		}
+	case roles.Is(constant.ROLE_DRIVER):
+		if driverID != uid {
+			return errors.New("no authorization")
+		}
+	default:
+		return errors.New("not allowed")
+	}
+
+	assignments, err := model.GetSchemeAssignments(driverID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, assignments)
+}
+
+// runAudit 立即审计记录的完整性
+func runAudit(c echo.Context) error {
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_ADMIN) {
+		return errors.New("not admin")
+	}
+
+	req := new(reqRunAudit)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	reports, err := req.runAudit()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, reports)
+}
+
+// getAuditReports 获取记录完整性审计报告
+func getAuditReports(c echo.Context) error {
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_ADMIN) {
+		return errors.New("not admin")
+	}
+
+	req := new(reqAuditReports)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	reports, err := req.getAuditReports()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, reports)
+}
+
+// getRUCReport 获取柴油车辆的RUC里程报告
+func getRUCReport(c echo.Context) error {
+
+	req := new(reqRUCReport)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	vehicleID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	vehicle, err := userApi.FindVehicle(vehicleID)
+	if err != nil {
+		return err
+	}
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	roles := utils.RolesAssert(c.Get("roles"))
+	switch {
+	case roles.Is(constant.ROLE_ADMIN):
+	case roles.Is(constant.ROLE_TO_SUPER), roles.Is(constant.ROLE_TO_ADMIN):
+		operatorIDs, err := driverOperators(uid, vehicle.DriverId)
+		if err != nil {
+			return err
+		}
+		if len(operatorIDs) == 0 {
+			return errors.New("no authorization")
+		}
+	case roles.Is(constant.ROLE_DRIVER):
+		if vehicle.DriverId != uid {
+			return errors.New("no authorization")
+		}
+	default:
+		return errors.New("not allowed")
+	}
+
+	report, err := req.getRUCReport(vehicle)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// getSuggestions 根据行驶位置获取建议添加的记录
+func getSuggestions(c echo.Context) error {
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_DRIVER) {
+		return errors.New("not driver")
+	}
+
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	suggestions, err := model.GetSuggestions(uid, time.Now())
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, suggestions)
+}
+
+// acceptSuggestion 将建议记录添加为记录
+func acceptSuggestion(c echo.Context) error {
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_DRIVER) {
+		return errors.New("not driver")
+	}
+
+	uid, _ := c.Get("user").(primitive.ObjectID)
+
+	req := new(reqAcceptSuggestion)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+	r, err := req.acceptSuggestion(uid)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, r)
 }
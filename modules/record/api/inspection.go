@@ -0,0 +1,150 @@
+package api
+
+import (
+	"errors"
+	"html/template"
+	"io"
+	"time"
+
+	valid "github.com/asaskevich/govalidator"
+	"github.com/chadhao/logit/modules/record/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// defaultInspectionDays 检查授权默认包含的天数
+	defaultInspectionDays = 14
+	// defaultInspectionTTL 检查授权默认有效时长
+	defaultInspectionTTL = time.Hour
+	// maxInspectionTTL 检查授权最长有效时长
+	maxInspectionTTL = 24 * time.Hour
+)
+
+// reqAddInspection 生成检查授权请求结构
+type reqAddInspection struct {
+	Days int    `json:"days" valid:"range(1|28),optional"`
+	TTL  string `json:"ttl" valid:"optional"`
+}
+
+// respInspection 检查授权返回结构
+type respInspection struct {
+	model.Inspection `json:",inline"`
+	Token            string `json:"token"`
+}
+
+// constructToInspection 将reqAddInspection构造为Inspection
+func (req *reqAddInspection) constructToInspection(driverID primitive.ObjectID) (*model.Inspection, error) {
+	if _, err := valid.ValidateStruct(req); err != nil {
+		return nil, err
+	}
+	if req.Days == 0 {
+		req.Days = defaultInspectionDays
+	}
+	ttl := defaultInspectionTTL
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil {
+			return nil, err
+		}
+	}
+	if ttl <= 0 || ttl > maxInspectionTTL {
+		return nil, errors.New("ttl out of range")
+	}
+	now := time.Now()
+	return &model.Inspection{
+		ID:        primitive.NewObjectID(),
+		DriverID:  driverID,
+		From:      now.AddDate(0, 0, -req.Days),
+		To:        now,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}, nil
+}
+
+// addInspection 生成检查授权并签发令牌
+func (req *reqAddInspection) addInspection(driverID primitive.ObjectID) (*respInspection, error) {
+	i, err := req.constructToInspection(driverID)
+	if err != nil {
+		return nil, err
+	}
+	// 先签发令牌, 未配置密钥时不保存检查授权
+	token, err := i.Token()
+	if err != nil {
+		return nil, err
+	}
+	if err = i.Add(); err != nil {
+		return nil, err
+	}
+	return &respInspection{Inspection: *i, Token: token}, nil
+}
+
+// inspectionLogbook 获取检查授权范围内的日志
+func inspectionLogbook(i *model.Inspection) (*logbook, error) {
+	req := &reqRecords{
+		DriverID: i.DriverID.Hex(),
+		From:     i.From,
+		To:       i.To,
+	}
+	return req.getLogbook()
+}
+
+var inspectionTemplate = template.Must(template.New("inspection").Funcs(template.FuncMap{
+	"clock":    func(t time.Time) string { return t.Format(logbookTimeFormat) },
+	"date":     func(t time.Time) string { return t.Format(logbookDateFormat) },
+	"duration": formatDuration,
+	"mileage":  formatMileAge,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Logbook inspection</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 1.5em; }
+th, td { border: 1px solid #999; padding: 4px; font-size: 0.9em; text-align: left; }
+th { background: #ddd; }
+.note td { border-top: none; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>Logbook inspection</h1>
+<p>Driver: {{.Logbook.Driver.Firstnames}} {{.Logbook.Driver.Surname}}<br>
+Licence: {{.Logbook.Driver.LicenseNumber}}<br>
+Period: {{date .Inspection.From}} - {{date .Inspection.To}}<br>
+Valid until: {{date .Inspection.ExpiresAt}} {{clock .Inspection.ExpiresAt}}<br>
+Chain head: {{.Logbook.ChainHead}}</p>
+{{range .Logbook.Days}}{{if .Entries}}
+<h2>{{date .Date}} {{clock .Date}} - {{date .End}} {{clock .End}}</h2>
+<table>
+<tr><th>Type</th><th>Start</th><th>End</th><th>Duration</th><th>Start location</th><th>End location</th><th>Vehicle</th><th>Odo start</th><th>Odo end</th></tr>
+{{range .Entries}}
+<tr><td>{{.Record.Type}}</td><td>{{clock .Start}}</td><td>{{clock .End}}</td><td>{{duration (.End.Sub .Start)}}</td>
+<td>{{.Record.StartLocation.Address}}</td><td>{{.Record.EndLocation.Address}}</td><td>{{index $.Registrations .Record.VehicleID}}</td>
+<td>{{mileage .Record.StartMileAge}}</td><td>{{mileage .Record.EndMileAge}}</td></tr>
+{{range .Notes}}<tr class="note"><td></td><td colspan="8">Note ({{index . "noteType"}}): {{index . "comment"}}</td></tr>{{end}}
+{{end}}
+</table>
+{{end}}{{end}}
+</body>
+</html>
+`))
+
+// renderInspection 输出只读HTML格式的检查视图
+func renderInspection(w io.Writer, i *model.Inspection, lb *logbook) error {
+	registrations := make(map[primitive.ObjectID]string)
+	for _, day := range lb.Days {
+		for _, e := range day.Entries {
+			registrations[e.Record.VehicleID] = lb.registration(e.Record.VehicleID)
+		}
+	}
+	// 时间按司机本地时区显示
+	inspection := *i
+	inspection.From = i.From.In(lb.Location)
+	inspection.To = i.To.In(lb.Location)
+	inspection.ExpiresAt = i.ExpiresAt.In(lb.Location)
+	return inspectionTemplate.Execute(w, map[string]interface{}{
+		"Inspection":    &inspection,
+		"Logbook":       lb,
+		"Registrations": registrations,
+	})
+}
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/chadhao/logit/modules/record/model"
+	userApi "github.com/chadhao/logit/modules/user/api"
+	userModel "github.com/chadhao/logit/modules/user/model"
+	"github.com/jung-kurt/gofpdf"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	logbookMargin     = 10.0
+	logbookGridLeft   = 30.0
+	logbookGridWidth  = 240.0
+	logbookGridRow    = 8.0
+	logbookTimeFormat = "15:04"
+	logbookDateFormat = "Mon 02 Jan 2006"
+	logbookDay24      = 24 * time.Hour
+)
+
+type (
+	// logbookEntry 某一工作日日志表中的一条记录, 跨工作日的记录按工作日截断
+	logbookEntry struct {
+		Record model.Record
+		Notes  model.DifNotes
+		Start  time.Time
+		End    time.Time
+	}
+	// logbookDay 一个工作日的日志表, Date为工作日开始时间
+	logbookDay struct {
+		Date    time.Time
+		End     time.Time
+		Entries []logbookEntry
+	}
+	// logbook 司机指定日期范围内的日志
+	logbook struct {
+		Driver   *userModel.Driver
+		Vehicles map[primitive.ObjectID]*userModel.Vehicle
+		Days     []logbookDay
+		// ChainHead 生成日志时司机哈希链的链头, 供第三方核对
+		ChainHead *model.ChainHead
+		// Location 司机的本地时区
+		Location *time.Location
+	}
+)
+
+// getLogbook 获取指定日期范围内按天分组的日志
+func (reqR *reqRecords) getLogbook() (*logbook, error) {
+	if err := reqR.valid(); err != nil {
+		return nil, err
+	}
+	driverID, err := primitive.ObjectIDFromHex(reqR.DriverID)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := userApi.FindDriver(driverID)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := model.DriverLocation(driverID)
+
+	// 工作日最长24小时, 前后各多取两天以确定跨越范围边界的工作日及其记录
+	records, err := model.GetRecords(driverID, reqR.From.Add(-2*logbookDay24), reqR.To.Add(2*logbookDay24), false)
+	if err != nil {
+		return nil, err
+	}
+	recordIDs := []primitive.ObjectID{}
+	for _, v := range records {
+		recordIDs = append(recordIDs, v.ID)
+	}
+	notesMap, err := model.GetNotesByRecordIDs(recordIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	chainHead, err := model.GetChainHead(driverID)
+	if err != nil {
+		return nil, err
+	}
+
+	lb := &logbook{
+		Driver:    driver,
+		Vehicles:  make(map[primitive.ObjectID]*userModel.Vehicle),
+		ChainHead: chainHead,
+		Location:  loc,
+	}
+	for _, v := range records {
+		if _, ok := lb.Vehicles[v.VehicleID]; ok {
+			continue
+		}
+		if vehicle, err := userApi.FindVehicle(v.VehicleID); err == nil {
+			lb.Vehicles[v.VehicleID] = vehicle
+		}
+	}
+	// 每个工作日一页, 而非按自然日在午夜拆分
+	schemes, err := model.GetDriverSchemes(driverID, reqR.To)
+	if err != nil {
+		return nil, err
+	}
+	for _, wd := range model.Records(records).WorkDays(schemes) {
+		if !wd.End.After(reqR.From) || !wd.Start.Before(reqR.To) {
+			continue
+		}
+		ld := logbookDay{Date: wd.Start.In(loc), End: wd.End.In(loc), Entries: []logbookEntry{}}
+		for _, v := range records {
+			start, end := v.Time.Add(-v.Duration), v.Time
+			if !start.Before(wd.End) || !end.After(wd.Start) {
+				continue
+			}
+			if start.Before(wd.Start) {
+				start = wd.Start
+			}
+			if end.After(wd.End) {
+				end = wd.End
+			}
+			ld.Entries = append(ld.Entries, logbookEntry{
+				Record: v,
+				Notes:  notesMap[v.ID],
+				Start:  start.In(loc),
+				End:    end.In(loc),
+			})
+		}
+		lb.Days = append(lb.Days, ld)
+	}
+	return lb, nil
+}
+
+// registration 获取车辆车牌
+func (lb *logbook) registration(id primitive.ObjectID) string {
+	if v, ok := lb.Vehicles[id]; ok {
+		return v.Registration
+	}
+	return id.Hex()
+}
+
+// render 按NZTA日志表格式输出PDF, 每个工作日一页
+func (lb *logbook) render(w io.Writer) error {
+	pdf := gofpdf.New("L", "mm", "A4", "")
+	pdf.SetMargins(logbookMargin, logbookMargin, logbookMargin)
+	pdf.SetAutoPageBreak(true, logbookMargin)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-logbookMargin)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Page %d", pdf.PageNo()), "", 0, "C", false, 0, "")
+		pdf.SetX(logbookMargin)
+		pdf.CellFormat(0, 5, "Chain head: "+lb.ChainHead.String(), "", 0, "R", false, 0, "")
+	})
+
+	for _, day := range lb.Days {
+		pdf.AddPage()
+		lb.renderHeader(pdf, tr, day)
+		renderTimeline(pdf, day)
+		lb.renderEntries(pdf, tr, day)
+		renderTotals(pdf, day)
+	}
+	if len(lb.Days) == 0 {
+		pdf.AddPage()
+	}
+	return pdf.Output(w)
+}
+
+func (lb *logbook) renderHeader(pdf *gofpdf.Fpdf, tr func(string) string, day logbookDay) {
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, 8, "Logbook - Daily Sheet", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	name := tr(fmt.Sprintf("%s %s", lb.Driver.Firstnames, lb.Driver.Surname))
+	pdf.CellFormat(100, 6, "Driver: "+name, "", 0, "L", false, 0, "")
+	pdf.CellFormat(80, 6, "Licence: "+tr(lb.Driver.LicenseNumber), "", 0, "L", false, 0, "")
+	pdf.CellFormat(0, 6, "Work day: "+day.Date.Format(logbookDateFormat+" "+logbookTimeFormat)+" - "+day.End.Format(logbookTimeFormat), "", 1, "L", false, 0, "")
+	pdf.Ln(2)
+}
+
+// renderTimeline 绘制从工作日开始起24小时的工作/休息时间格
+func renderTimeline(pdf *gofpdf.Fpdf, day logbookDay) {
+	top := pdf.GetY()
+	hour := logbookGridWidth / 24
+
+	pdf.SetFont("Helvetica", "", 7)
+	for h := 0; h <= 24; h++ {
+		pdf.SetXY(logbookGridLeft+float64(h)*hour-4, top)
+		pdf.CellFormat(8, 4, day.Date.Add(time.Duration(h)*time.Hour).Format(logbookTimeFormat), "", 0, "C", false, 0, "")
+	}
+	top += 4
+
+	rows := []struct {
+		label   string
+		recType model.Type
+	}{{"Driving", model.DRIVING}, {"Other work", model.OTHERWORK}, {"Rest", model.REST}, {"Off duty", model.OFFDUTY}}
+	pdf.SetFont("Helvetica", "B", 9)
+	for i, row := range rows {
+		y := top + float64(i)*logbookGridRow
+		pdf.SetXY(logbookMargin, y)
+		pdf.CellFormat(logbookGridLeft-logbookMargin, logbookGridRow, row.label, "", 0, "L", false, 0, "")
+		pdf.SetFillColor(60, 60, 60)
+		for _, e := range day.Entries {
+			if e.Record.Type != row.recType {
+				continue
+			}
+			x := logbookGridLeft + e.Start.Sub(day.Date).Hours()*hour
+			width := e.End.Sub(e.Start).Hours() * hour
+			pdf.Rect(x, y+2, width, logbookGridRow-4, "F")
+		}
+	}
+
+	// 网格线, 每小时一条实线, 每15分钟一条短线
+	pdf.SetDrawColor(0, 0, 0)
+	pdf.SetLineWidth(0.1)
+	height := logbookGridRow * float64(len(rows))
+	pdf.Rect(logbookGridLeft, top, logbookGridWidth, height, "D")
+	for i := range rows {
+		pdf.Line(logbookGridLeft, top+float64(i)*logbookGridRow, logbookGridLeft+logbookGridWidth, top+float64(i)*logbookGridRow)
+	}
+	for q := 1; q < 96; q++ {
+		x := logbookGridLeft + float64(q)*hour/4
+		if q%4 == 0 {
+			pdf.Line(x, top, x, top+height)
+			continue
+		}
+		for i := range rows {
+			y := top + float64(i)*logbookGridRow
+			pdf.Line(x, y, x, y+1.5)
+		}
+	}
+	pdf.SetY(top + height + 4)
+}
+
+func (lb *logbook) renderEntries(pdf *gofpdf.Fpdf, tr func(string) string, day logbookDay) {
+	cols := []struct {
+		title string
+		width float64
+	}{
+		{"Type", 16}, {"Start", 14}, {"End", 14}, {"Duration", 18},
+		{"Start location", 70}, {"End location", 70}, {"Vehicle", 25}, {"Odo start", 25}, {"Odo end", 25},
+	}
+	pdf.SetFont("Helvetica", "B", 8)
+	pdf.SetFillColor(220, 220, 220)
+	for _, c := range cols {
+		pdf.CellFormat(c.width, 6, c.title, "1", 0, "L", true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("Helvetica", "", 8)
+	for _, e := range day.Entries {
+		r := e.Record
+		values := []string{
+			string(r.Type),
+			e.Start.In(day.Date.Location()).Format(logbookTimeFormat),
+			e.End.In(day.Date.Location()).Format(logbookTimeFormat),
+			formatDuration(e.End.Sub(e.Start)),
+			tr(string(r.StartLocation.Address)),
+			tr(string(r.EndLocation.Address)),
+			tr(lb.registration(r.VehicleID)),
+			formatMileAge(r.StartMileAge),
+			formatMileAge(r.EndMileAge),
+		}
+		for i, c := range cols {
+			pdf.CellFormat(c.width, 6, truncate(pdf, values[i], c.width-1), "1", 0, "L", false, 0, "")
+		}
+		pdf.Ln(-1)
+		for _, n := range e.Notes {
+			comment, _ := n["comment"].(string)
+			pdf.CellFormat(16, 5, "", "", 0, "L", false, 0, "")
+			pdf.CellFormat(0, 5, tr(fmt.Sprintf("Note (%v): %s", n["noteType"], comment)), "", 1, "L", false, 0, "")
+		}
+	}
+	pdf.Ln(3)
+}
+
+// renderTotals 输出当天的驾驶、其它工作、休息及行驶里程合计
+func renderTotals(pdf *gofpdf.Fpdf, day logbookDay) {
+	var driving, otherWork, rest time.Duration
+	var distance float64
+	for _, e := range day.Entries {
+		switch {
+		case e.Record.Type == model.DRIVING:
+			driving += e.End.Sub(e.Start)
+		case e.Record.Type.IsWork():
+			otherWork += e.End.Sub(e.Start)
+		default:
+			rest += e.End.Sub(e.Start)
+		}
+		// 里程计入记录结束的那一天
+		if e.Record.StartMileAge != nil && e.Record.EndMileAge != nil && e.End.Equal(e.Record.Time) {
+			distance += *e.Record.EndMileAge - *e.Record.StartMileAge
+		}
+	}
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.CellFormat(50, 6, "Total driving: "+formatDuration(driving), "1", 0, "L", false, 0, "")
+	pdf.CellFormat(50, 6, "Total other work: "+formatDuration(otherWork), "1", 0, "L", false, 0, "")
+	pdf.CellFormat(50, 6, "Total rest: "+formatDuration(rest), "1", 0, "L", false, 0, "")
+	pdf.CellFormat(50, 6, fmt.Sprintf("Distance: %.1f km", distance), "1", 1, "L", false, 0, "")
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+func formatMileAge(m *float64) string {
+	if m == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", *m)
+}
+
+// truncate 截断超出单元格宽度的文本
+func truncate(pdf *gofpdf.Fpdf, s string, width float64) string {
+	for pdf.GetStringWidth(s) > width && len(s) > 0 {
+		s = s[:len(s)-1]
+	}
+	return s
+}
@@ -6,18 +6,34 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 
 	valid "github.com/asaskevich/govalidator"
 	"github.com/chadhao/logit/modules/record/model"
+	userApi "github.com/chadhao/logit/modules/user/api"
+	userModel "github.com/chadhao/logit/modules/user/model"
 )
 
 // reqRecords 请求获取记录
 type reqRecords struct {
-	DriverID string    `query:"driverID" valid:"required"`
-	From     time.Time `query:"from" valid:"required"`
-	To       time.Time `query:"to" valid:"optional"`
+	DriverID   string    `query:"driverID" valid:"required"`
+	From       time.Time `query:"from" valid:"required"`
+	To         time.Time `query:"to" valid:"optional"`
+	Type       string    `query:"type" valid:"in(driving|other_work|rest|off_duty),optional"`
+	VehicleID  string    `query:"vehicleID" valid:"optional"`
+	HasNotes   string    `query:"hasNotes" valid:"in(true|false),optional"`
+	GetDeleted bool      `query:"getDeleted" valid:"optional"`
+	Cursor     string    `query:"cursor" valid:"optional"`
+	Limit      int       `query:"limit" valid:"optional"`
 }
 
+const (
+	// defaultRecordsLimit 每页默认记录数
+	defaultRecordsLimit = 100
+	// maxRecordsLimit 每页最大记录数
+	maxRecordsLimit = 1000
+)
+
 func (reqR *reqRecords) valid() error {
 	if _, err := valid.ValidateStruct(reqR); err != nil {
 		return err
@@ -31,40 +47,139 @@ func (reqR *reqRecords) valid() error {
 	return nil
 }
 
-// getRecords 获取指定时间范围内的记录
-func (reqR *reqRecords) getRecords() ([]*respRecord, error) {
+// filter 将请求转换为分页查询条件
+func (reqR *reqRecords) filter() (*model.RecordFilter, error) {
+	driverID, err := primitive.ObjectIDFromHex(reqR.DriverID)
+	if err != nil {
+		return nil, err
+	}
+	f := &model.RecordFilter{
+		DriverID:   driverID,
+		From:       reqR.From,
+		To:         reqR.To,
+		Type:       model.Type(reqR.Type),
+		GetDeleted: reqR.GetDeleted,
+		Limit:      reqR.Limit,
+	}
+	if f.Limit <= 0 {
+		f.Limit = defaultRecordsLimit
+	}
+	if f.Limit > maxRecordsLimit {
+		f.Limit = maxRecordsLimit
+	}
+	if reqR.VehicleID != "" {
+		vehicleID, err := primitive.ObjectIDFromHex(reqR.VehicleID)
+		if err != nil {
+			return nil, err
+		}
+		f.VehicleID = &vehicleID
+	}
+	if reqR.HasNotes != "" {
+		hasNotes := reqR.HasNotes == "true"
+		f.HasNotes = &hasNotes
+	}
+	if reqR.Cursor != "" {
+		if f.After, err = model.DecodePageCursor(reqR.Cursor); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// paginated 指定cursor或limit时分页返回, 否则按原有格式返回全部记录的数组
+func (reqR *reqRecords) paginated() bool {
+	return reqR.Cursor != "" || reqR.Limit > 0
+}
+
+// getAllRecords 获取指定时间范围内的全部记录, 不分页
+func (reqR *reqRecords) getAllRecords() ([]*respRecord, error) {
 	if err := reqR.valid(); err != nil {
 		return nil, err
 	}
-	driverID, err := primitive.ObjectIDFromHex(reqR.DriverID)
+	f, err := reqR.filter()
 	if err != nil {
 		return nil, err
 	}
-
-	// 获取记录
-	records, err := model.GetRecords(driverID, reqR.From, reqR.To, false)
+	f.Limit = 0
+	records, err := model.FindRecords(f)
 	if err != nil {
 		return nil, err
 	}
-	// 获取记录下的笔记
-	recordIDs := []primitive.ObjectID{}
+	resp := []*respRecord{}
 	for _, v := range records {
-		recordIDs = append(recordIDs, v.ID)
+		resp = append(resp, &respRecord{
+			Record: v.Record,
+			Notes:  v.Notes,
+		})
+	}
+	return resp, nil
+}
+
+// getRecords 分页获取指定时间范围内的记录
+func (reqR *reqRecords) getRecords() (*respRecords, error) {
+	if err := reqR.valid(); err != nil {
+		return nil, err
 	}
-	notesMap, err := model.GetNotesByRecordIDs(recordIDs)
+	f, err := reqR.filter()
 	if err != nil {
 		return nil, err
 	}
+
+	// 多取一条以判断是否还有下一页
+	f.Limit++
+	records, err := model.FindRecords(f)
+	if err != nil {
+		return nil, err
+	}
+	f.Limit--
+
 	// 拼装返回
-	respRecords := []*respRecord{}
-	for _, v := range records {
-		respRecords = append(respRecords, &respRecord{
-			Record: v,
-			Notes:  notesMap[v.ID],
+	resp := &respRecords{Records: []*respRecord{}}
+	for i, v := range records {
+		if i == f.Limit {
+			last := records[i-1]
+			resp.NextCursor = (&model.PageCursor{Time: last.Time, ID: last.ID}).Encode()
+			break
+		}
+		resp.Records = append(resp.Records, &respRecord{
+			Record: v.Record,
+			Notes:  v.Notes,
 		})
 	}
 
-	return respRecords, nil
+	return resp, nil
+}
+
+// getViolations 获取指定时间范围内的违规情况
+func (reqR *reqRecords) getViolations() ([]model.Violation, error) {
+	if err := reqR.valid(); err != nil {
+		return nil, err
+	}
+	driverID, err := primitive.ObjectIDFromHex(reqR.DriverID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 每条记录按其发生时适用的方案检查
+	schemes, err := model.GetDriverSchemes(driverID, reqR.To)
+	if err != nil {
+		return nil, err
+	}
+
+	// 向前追溯至累计工作重置的休息，以便正确计算范围开始时的累计工时
+	records, err := model.GetComplianceRecords(driverID, reqR.From, reqR.To, schemes)
+	if err != nil {
+		return nil, err
+	}
+
+	violations := []model.Violation{}
+	for _, v := range records.Violations(schemes) {
+		if v.End.Before(reqR.From) {
+			continue
+		}
+		violations = append(violations, v)
+	}
+	return violations, nil
 }
 
 // reqRecord 请求获取记录
@@ -106,6 +221,78 @@ func (reqRecord *reqRecord) deleteRecord(driverID primitive.ObjectID) error {
 	return r.Delete()
 }
 
+// reqAmendRecord 修改记录请求结构
+type reqAmendRecord struct {
+	ID            primitive.ObjectID `json:"-" valid:"-"`
+	Type          *model.Type        `json:"type,omitempty" valid:"-"`
+	Time          *time.Time         `json:"time,omitempty" valid:"-"`
+	Duration      *string            `json:"duration,omitempty" valid:"-"`
+	StartLocation *model.Location    `json:"startLocation,omitempty" valid:"-"`
+	EndLocation   *model.Location    `json:"endLocation,omitempty" valid:"-"`
+	StartMileAge  *float64           `json:"startDistance,omitempty" valid:"-"`
+	EndMileAge    *float64           `json:"endDistance,omitempty" valid:"-"`
+	Reason        string             `json:"reason" valid:"required"`
+}
+
+// constructToAmendedRecord 将修改内容应用到原记录的副本上
+func (req *reqAmendRecord) constructToAmendedRecord(r *model.Record) (*model.Record, error) {
+	if _, err := valid.ValidateStruct(req); err != nil {
+		return nil, err
+	}
+	amended := *r
+	if req.Type != nil {
+		amended.Type = *req.Type
+	}
+	if req.Time != nil {
+		if req.Time.After(time.Now()) {
+			return nil, errors.New("cannot amend to future time")
+		}
+		amended.Time = *req.Time
+	}
+	if req.Duration != nil {
+		duration, err := time.ParseDuration(*req.Duration)
+		if err != nil {
+			return nil, err
+		}
+		amended.Duration = duration
+	}
+	if req.StartLocation != nil {
+		amended.StartLocation = *req.StartLocation
+	}
+	if req.EndLocation != nil {
+		amended.EndLocation = *req.EndLocation
+	}
+	if req.StartMileAge != nil {
+		amended.StartMileAge = req.StartMileAge
+	}
+	if req.EndMileAge != nil {
+		amended.EndMileAge = req.EndMileAge
+	}
+	if amended.StartMileAge != nil && amended.EndMileAge != nil && *amended.StartMileAge > *amended.EndMileAge {
+		return nil, errors.New("startMileAge should be less than endMileAge")
+	}
+	return &amended, nil
+}
+
+// amendRecord 修改记录并返回修改后的记录
+func (req *reqAmendRecord) amendRecord(r *model.Record, by primitive.ObjectID) (*respRecord, error) {
+	amended, err := req.constructToAmendedRecord(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = r.Amend(amended, by, req.Reason); err != nil {
+		return nil, err
+	}
+	notesMap, err := model.GetNotesByRecordIDs([]primitive.ObjectID{r.ID})
+	if err != nil {
+		return nil, err
+	}
+	return &respRecord{
+		Record: *amended,
+		Notes:  notesMap[r.ID],
+	}, nil
+}
+
 // reqAddRecord 添加记录请求结构
 type reqAddRecord struct {
 	Type          model.Type         `json:"type" valid:"required"`
@@ -117,11 +304,15 @@ type reqAddRecord struct {
 	StartMileAge  *float64           `json:"startDistance,omitempty" valid:"-"`
 	EndMileAge    *float64           `json:"endDistance,omitempty" valid:"-"`
 	ClientTime    *time.Time         `json:"clientTime,omitempty" valid:"-"`
+	ClientID      string             `json:"clientID,omitempty" valid:"-"`
+	// 双人驾驶
+	TeamTripID      *primitive.ObjectID `json:"teamTripID,omitempty" valid:"-"`
+	InMovingVehicle bool                `json:"inMovingVehicle,omitempty" valid:"-"`
 }
 
 // Valid 添加记录请求结构验证
 func (reqAddR *reqAddRecord) valid() error {
-	if reqAddR.Type != model.WORK && reqAddR.Type != model.REST {
+	if !reqAddR.Type.Valid() {
 		return errors.New("no match type")
 	}
 	if _, err := valid.ValidateStruct(reqAddR); err != nil {
@@ -144,12 +335,15 @@ func (reqAddR *reqAddRecord) valid() error {
 
 // syncValid 上传记录请求结构验证
 func (reqAddR *reqAddRecord) syncValid() error {
-	if reqAddR.Type != model.WORK && reqAddR.Type != model.REST {
+	if !reqAddR.Type.Valid() {
 		return errors.New("no match type")
 	}
 	if reqAddR.ClientTime == nil {
 		return errors.New("clientTime is required")
 	}
+	if reqAddR.ClientID == "" {
+		return errors.New("clientID is required")
+	}
 	if _, err := valid.ValidateStruct(reqAddR); err != nil {
 		return err
 	}
@@ -178,18 +372,21 @@ func (reqAddR *reqAddRecord) constructToRecord(driverID primitive.ObjectID) (*mo
 		return nil, err
 	}
 	r := &model.Record{
-		ID:            primitive.NewObjectID(),
-		DriverID:      driverID,
-		Type:          reqAddR.Type,
-		Time:          reqAddR.Time,
-		Duration:      duration,
-		StartLocation: reqAddR.StartLocation,
-		EndLocation:   reqAddR.EndLocation,
-		VehicleID:     reqAddR.VehicleID,
-		StartMileAge:  reqAddR.StartMileAge,
-		EndMileAge:    reqAddR.EndMileAge,
-		ClientTime:    reqAddR.ClientTime,
-		CreatedAt:     time.Now(),
+		ID:              primitive.NewObjectID(),
+		DriverID:        driverID,
+		Type:            reqAddR.Type,
+		Time:            reqAddR.Time,
+		Duration:        duration,
+		StartLocation:   reqAddR.StartLocation,
+		EndLocation:     reqAddR.EndLocation,
+		VehicleID:       reqAddR.VehicleID,
+		StartMileAge:    reqAddR.StartMileAge,
+		EndMileAge:      reqAddR.EndMileAge,
+		ClientTime:      reqAddR.ClientTime,
+		ClientID:        reqAddR.ClientID,
+		CreatedAt:       time.Now(),
+		TeamTripID:      reqAddR.TeamTripID,
+		InMovingVehicle: reqAddR.InMovingVehicle,
 	}
 	return r, nil
 }
@@ -204,18 +401,21 @@ func (reqAddR *reqAddRecord) constructToSyncRecord(driverID primitive.ObjectID)
 		return nil, err
 	}
 	r := &model.Record{
-		ID:            primitive.NewObjectID(),
-		DriverID:      driverID,
-		Type:          reqAddR.Type,
-		Time:          reqAddR.Time,
-		Duration:      duration,
-		StartLocation: reqAddR.StartLocation,
-		EndLocation:   reqAddR.EndLocation,
-		VehicleID:     reqAddR.VehicleID,
-		StartMileAge:  reqAddR.StartMileAge,
-		EndMileAge:    reqAddR.EndMileAge,
-		ClientTime:    reqAddR.ClientTime,
-		CreatedAt:     time.Now(),
+		ID:              primitive.NewObjectID(),
+		DriverID:        driverID,
+		Type:            reqAddR.Type,
+		Time:            reqAddR.Time,
+		Duration:        duration,
+		StartLocation:   reqAddR.StartLocation,
+		EndLocation:     reqAddR.EndLocation,
+		VehicleID:       reqAddR.VehicleID,
+		StartMileAge:    reqAddR.StartMileAge,
+		EndMileAge:      reqAddR.EndMileAge,
+		ClientTime:      reqAddR.ClientTime,
+		ClientID:        reqAddR.ClientID,
+		CreatedAt:       time.Now(),
+		TeamTripID:      reqAddR.TeamTripID,
+		InMovingVehicle: reqAddR.InMovingVehicle,
 	}
 	return r, nil
 }
@@ -224,6 +424,12 @@ type reqAddNote struct {
 	NoteType model.NoteType     `json:"noteType" valid:"required"`
 	RecordID primitive.ObjectID `json:"recordID" valid:"required"`
 	Comment  string             `json:"comment" valid:"optional"`
+	// 行程笔记
+	TransportOperatorID *primitive.ObjectID `json:"transportOperatorID,omitempty" valid:"-"`
+	StartTime           time.Time           `json:"startTime,omitempty" valid:"-"`
+	EndTime             time.Time           `json:"endTime,omitempty" valid:"-"`
+	StartLocation       model.Location      `json:"startLocation,omitempty" valid:"-"`
+	EndLocation         model.Location      `json:"endLocation,omitempty" valid:"-"`
 }
 
 // valid 添加笔记验证
@@ -284,6 +490,36 @@ func (r *reqAddNote) constructToModificationNote(by primitive.ObjectID) (*model.
 	return mn, nil
 }
 
+// constructToTripNote 将reqAddNote构造为TripNote
+func (r *reqAddNote) constructToTripNote() (*model.TripNote, error) {
+	if err := r.valid(); err != nil {
+		return nil, err
+	}
+	rec, err := model.GetRecord(r.RecordID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validTransportOperator(rec.DriverID, r.TransportOperatorID); err != nil {
+		return nil, err
+	}
+	tn := &model.TripNote{
+		Note: model.Note{
+			ID:        primitive.NewObjectID(),
+			RecordID:  r.RecordID,
+			Type:      r.NoteType,
+			Comment:   r.Comment,
+			CreatedAt: time.Now(),
+		},
+		DriverID:            rec.DriverID,
+		TransportOperatorID: r.TransportOperatorID,
+		StartTime:           r.StartTime,
+		EndTime:             r.EndTime,
+		StartLocation:       r.StartLocation,
+		EndLocation:         r.EndLocation,
+	}
+	return tn, nil
+}
+
 func (r *reqAddNote) isDriversRecord(driverID primitive.ObjectID) bool {
 	rec, err := model.GetRecord(r.RecordID)
 	if err != nil {
@@ -291,3 +527,372 @@ func (r *reqAddNote) isDriversRecord(driverID primitive.ObjectID) bool {
 	}
 	return rec.DriverID == driverID
 }
+
+// validTransportOperator 检查运输公司是否为司机所属的运输公司
+func validTransportOperator(driverID primitive.ObjectID, operatorID *primitive.ObjectID) error {
+	if operatorID == nil {
+		return nil
+	}
+	driver, err := userApi.FindDriver(driverID)
+	if err != nil {
+		return err
+	}
+	for _, v := range driver.TransportOperatorIds {
+		if v == *operatorID {
+			return nil
+		}
+	}
+	return errors.New("transport operator not match driver")
+}
+
+// reqTripNotes 请求获取行程笔记
+type reqTripNotes struct {
+	DriverID            string    `query:"driverID" valid:"required"`
+	From                time.Time `query:"from" valid:"required"`
+	To                  time.Time `query:"to" valid:"optional"`
+	TransportOperatorID string    `query:"transportOperatorID" valid:"optional"`
+}
+
+// getTripNotes 获取指定时间范围内的行程笔记
+func (req *reqTripNotes) getTripNotes() ([]model.TripNote, error) {
+	if _, err := valid.ValidateStruct(req); err != nil {
+		return nil, err
+	}
+	if req.To.IsZero() {
+		req.To = time.Now()
+	}
+	if req.From.After(req.To) {
+		return nil, errors.New("times order is wrong")
+	}
+	driverID, err := primitive.ObjectIDFromHex(req.DriverID)
+	if err != nil {
+		return nil, err
+	}
+	var operatorID *primitive.ObjectID
+	if req.TransportOperatorID != "" {
+		id, err := primitive.ObjectIDFromHex(req.TransportOperatorID)
+		if err != nil {
+			return nil, err
+		}
+		operatorID = &id
+	}
+	return model.GetTripNotes(driverID, req.From, req.To, operatorID)
+}
+
+// reqUpdateTripNote 修改行程笔记请求结构
+type reqUpdateTripNote struct {
+	Comment             *string             `json:"comment,omitempty" valid:"-"`
+	TransportOperatorID *primitive.ObjectID `json:"transportOperatorID,omitempty" valid:"-"`
+	StartTime           *time.Time          `json:"startTime,omitempty" valid:"-"`
+	EndTime             *time.Time          `json:"endTime,omitempty" valid:"-"`
+	StartLocation       *model.Location     `json:"startLocation,omitempty" valid:"-"`
+	EndLocation         *model.Location     `json:"endLocation,omitempty" valid:"-"`
+}
+
+// updateTripNote 将修改内容应用到行程笔记并保存
+func (req *reqUpdateTripNote) updateTripNote(tn *model.TripNote) error {
+	if req.Comment != nil {
+		tn.Comment = *req.Comment
+	}
+	if req.TransportOperatorID != nil {
+		if err := validTransportOperator(tn.DriverID, req.TransportOperatorID); err != nil {
+			return err
+		}
+		tn.TransportOperatorID = req.TransportOperatorID
+	}
+	if req.StartTime != nil {
+		tn.StartTime = *req.StartTime
+	}
+	if req.EndTime != nil {
+		tn.EndTime = *req.EndTime
+	}
+	if req.StartLocation != nil {
+		tn.StartLocation = *req.StartLocation
+	}
+	if req.EndLocation != nil {
+		tn.EndLocation = *req.EndLocation
+	}
+	return tn.Update()
+}
+
+// reqAddTeamTrip 创建双人驾驶行程请求结构
+type reqAddTeamTrip struct {
+	CoDriverID primitive.ObjectID `json:"coDriverID" valid:"required"`
+	VehicleID  primitive.ObjectID `json:"vehicleID" valid:"required"`
+	StartTime  time.Time          `json:"startTime" valid:"-"`
+}
+
+// constructToTeamTrip 将reqAddTeamTrip构造为TeamTrip
+func (req *reqAddTeamTrip) constructToTeamTrip(driverID primitive.ObjectID) (*model.TeamTrip, error) {
+	if _, err := valid.ValidateStruct(req); err != nil {
+		return nil, err
+	}
+	if _, err := userApi.FindDriver(req.CoDriverID); err != nil {
+		return nil, errors.New("co-driver not found")
+	}
+	if _, err := userApi.FindVehicle(req.VehicleID); err != nil {
+		return nil, errors.New("vehicle not found")
+	}
+	now := time.Now()
+	if req.StartTime.IsZero() {
+		req.StartTime = now
+	}
+	if req.StartTime.After(now) {
+		return nil, errors.New("cannot add future time")
+	}
+	return &model.TeamTrip{
+		ID:        primitive.NewObjectID(),
+		VehicleID: req.VehicleID,
+		DriverIDs: []primitive.ObjectID{driverID, req.CoDriverID},
+		StartTime: req.StartTime,
+		CreatedBy: driverID,
+		CreatedAt: now,
+	}, nil
+}
+
+// reqEndTeamTrip 结束双人驾驶行程请求结构
+type reqEndTeamTrip struct {
+	EndTime time.Time `json:"endTime" valid:"-"`
+}
+
+// reqOdometer 请求获取车辆里程时间线
+type reqOdometer struct {
+	From time.Time `query:"from" valid:"required"`
+	To   time.Time `query:"to" valid:"optional"`
+}
+
+// getOdometer 获取车辆最近里程读数及时间段内的里程时间线
+func (req *reqOdometer) getOdometer(vehicleID primitive.ObjectID) (*respOdometer, error) {
+	if _, err := valid.ValidateStruct(req); err != nil {
+		return nil, err
+	}
+	if req.To.IsZero() {
+		req.To = time.Now()
+	}
+	if req.From.After(req.To) {
+		return nil, errors.New("times order is wrong")
+	}
+	resp := &respOdometer{}
+	reading, err := model.GetOdometerReading(vehicleID)
+	switch {
+	case err == nil:
+		resp.Reading = reading
+	case err != mongo.ErrNoDocuments:
+		return nil, err
+	}
+	if resp.Timeline, err = model.GetOdometerTimeline(vehicleID, req.From, req.To); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// reqSummaries 请求获取工时汇总
+type reqSummaries struct {
+	DriverID string    `query:"driverID" json:"driverID" valid:"required"`
+	Period   string    `query:"period" json:"-" valid:"in(day|week|workday),optional"`
+	From     time.Time `query:"from" json:"from" valid:"required"`
+	To       time.Time `query:"to" json:"to" valid:"optional"`
+}
+
+func (req *reqSummaries) valid() error {
+	if _, err := valid.ValidateStruct(req); err != nil {
+		return err
+	}
+	if req.Period == "" {
+		req.Period = string(model.DAILY)
+	}
+	if req.To.IsZero() {
+		req.To = time.Now()
+	}
+	if req.From.After(req.To) {
+		return errors.New("times order is wrong")
+	}
+	return nil
+}
+
+// getSummaries 获取指定时间范围内的日汇总或周汇总
+func (req *reqSummaries) getSummaries() ([]model.Summary, error) {
+	if err := req.valid(); err != nil {
+		return nil, err
+	}
+	driverID, err := primitive.ObjectIDFromHex(req.DriverID)
+	if err != nil {
+		return nil, err
+	}
+	return model.GetSummaries(driverID, model.SummaryPeriod(req.Period), req.From, req.To)
+}
+
+// rebuildSummaries 重新计算指定时间范围内的汇总
+func (req *reqSummaries) rebuildSummaries() error {
+	if err := req.valid(); err != nil {
+		return err
+	}
+	driverID, err := primitive.ObjectIDFromHex(req.DriverID)
+	if err != nil {
+		return err
+	}
+	return model.UpdateSummaries(driverID, req.From, req.To)
+}
+
+// reqRestoreArchives 恢复归档数据请求结构
+type reqRestoreArchives struct {
+	DriverID primitive.ObjectID `json:"driverID" valid:"required"`
+	From     time.Time          `json:"from" valid:"required"`
+	To       time.Time          `json:"to" valid:"required"`
+	Days     int                `json:"days" valid:"range(1|90),optional"`
+}
+
+// restoreArchives 恢复司机指定时间范围内的归档数据
+func (req *reqRestoreArchives) restoreArchives() ([]model.ArchiveManifest, error) {
+	if _, err := valid.ValidateStruct(req); err != nil {
+		return nil, err
+	}
+	if req.From.After(req.To) {
+		return nil, errors.New("times order is wrong")
+	}
+	return model.RestoreArchives(req.DriverID, req.From, req.To, req.Days)
+}
+
+// reqAddScheme 创建疲劳管理方案请求结构, 时长均以小时为单位
+type reqAddScheme struct {
+	Name                string              `json:"name" valid:"required"`
+	Description         string              `json:"description" valid:"-"`
+	TransportOperatorID *primitive.ObjectID `json:"transportOperatorID" valid:"-"`
+	ContinuousWork      model.HrTime        `json:"continuousWork" valid:"-"`
+	ContinuousBreak     model.HrTime        `json:"continuousBreak" valid:"-"`
+	WorkDay             model.HrTime        `json:"workDay" valid:"-"`
+	DailyWork           model.HrTime        `json:"dailyWork" valid:"-"`
+	DailyRest           model.HrTime        `json:"dailyRest" valid:"-"`
+	CumulativeWork      model.HrTime        `json:"cumulativeWork" valid:"-"`
+	CumulativeRest      model.HrTime        `json:"cumulativeRest" valid:"-"`
+}
+
+// constructToScheme 将reqAddScheme构造为Scheme
+func (req *reqAddScheme) constructToScheme(createdBy primitive.ObjectID) (*model.Scheme, error) {
+	if _, err := valid.ValidateStruct(req); err != nil {
+		return nil, err
+	}
+	return &model.Scheme{
+		ID:                  primitive.NewObjectID(),
+		Name:                req.Name,
+		Description:         req.Description,
+		TransportOperatorID: req.TransportOperatorID,
+		ContinuousWork:      req.ContinuousWork,
+		ContinuousBreak:     req.ContinuousBreak,
+		WorkDay:             req.WorkDay,
+		DailyWork:           req.DailyWork,
+		DailyRest:           req.DailyRest,
+		CumulativeWork:      req.CumulativeWork,
+		CumulativeRest:      req.CumulativeRest,
+		CreatedBy:           createdBy,
+		CreatedAt:           time.Now(),
+	}, nil
+}
+
+// reqAssignScheme 为司机分配方案请求结构, SchemeID为空表示恢复默认方案
+type reqAssignScheme struct {
+	DriverID primitive.ObjectID `json:"driverID" valid:"required"`
+	SchemeID primitive.ObjectID `json:"schemeID" valid:"-"`
+	From     time.Time          `json:"from" valid:"required"`
+}
+
+// constructToSchemeAssignment 将reqAssignScheme构造为SchemeAssignment
+func (req *reqAssignScheme) constructToSchemeAssignment(createdBy primitive.ObjectID) (*model.SchemeAssignment, error) {
+	if _, err := valid.ValidateStruct(req); err != nil {
+		return nil, err
+	}
+	if _, err := userApi.FindDriver(req.DriverID); err != nil {
+		return nil, errors.New("driver not found")
+	}
+	return &model.SchemeAssignment{
+		ID:        primitive.NewObjectID(),
+		DriverID:  req.DriverID,
+		SchemeID:  req.SchemeID,
+		From:      req.From,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// reqAuditReports 请求获取审计报告
+type reqAuditReports struct {
+	DriverID string    `query:"driverID" valid:"optional"`
+	From     time.Time `query:"from" valid:"required"`
+	To       time.Time `query:"to" valid:"optional"`
+}
+
+// getAuditReports 获取时间段内的审计报告, 未指定司机时获取全部司机的报告
+func (req *reqAuditReports) getAuditReports() ([]model.AuditReport, error) {
+	if _, err := valid.ValidateStruct(req); err != nil {
+		return nil, err
+	}
+	if req.To.IsZero() {
+		req.To = time.Now()
+	}
+	var driverID primitive.ObjectID
+	if req.DriverID != "" {
+		var err error
+		if driverID, err = primitive.ObjectIDFromHex(req.DriverID); err != nil {
+			return nil, err
+		}
+	}
+	return model.GetAuditReports(driverID, req.From, req.To)
+}
+
+// reqRunAudit 立即执行审计请求结构, 未指定司机时审计全部司机
+type reqRunAudit struct {
+	DriverID *primitive.ObjectID `json:"driverID" valid:"-"`
+}
+
+// runAudit 立即执行审计
+func (req *reqRunAudit) runAudit() ([]model.AuditReport, error) {
+	if req.DriverID == nil {
+		return model.RunAudit(nil)
+	}
+	return model.RunAudit([]primitive.ObjectID{*req.DriverID})
+}
+
+// reqRUCReport 请求获取车辆RUC里程报告
+type reqRUCReport struct {
+	From time.Time `query:"from" valid:"required"`
+	To   time.Time `query:"to" valid:"optional"`
+}
+
+// getRUCReport 获取柴油车辆时间段内的里程报告, 车辆有RUC许可时预计许可用完的时间
+func (req *reqRUCReport) getRUCReport(vehicle *userModel.Vehicle) (*model.RUCReport, error) {
+	if _, err := valid.ValidateStruct(req); err != nil {
+		return nil, err
+	}
+	if !vehicle.IsDiesel {
+		return nil, errors.New("vehicle does not pay road user charges")
+	}
+	if req.To.IsZero() {
+		req.To = time.Now()
+	}
+	if req.From.After(req.To) {
+		return nil, errors.New("times order is wrong")
+	}
+	report, err := model.GetRUCReport(vehicle.Id, req.From, req.To)
+	if err != nil {
+		return nil, err
+	}
+	if vehicle.RUCLicence != nil {
+		if _, err = report.Project(vehicle.RUCLicence.From, vehicle.RUCLicence.To); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// reqAcceptSuggestion 接受建议记录请求, Time为建议记录的结束时间
+type reqAcceptSuggestion struct {
+	Time time.Time `json:"time" valid:"required"`
+}
+
+// acceptSuggestion 将第一条建议添加为记录
+func (req *reqAcceptSuggestion) acceptSuggestion(driverID primitive.ObjectID) (*model.Record, error) {
+	if _, err := valid.ValidateStruct(req); err != nil {
+		return nil, err
+	}
+	return model.AcceptSuggestion(driverID, req.Time)
+}
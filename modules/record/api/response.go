@@ -9,3 +9,21 @@ type respRecord struct {
 	model.Record `json:",inline"`
 	Notes        model.DifNotes `json:"notes,omitempty"`
 }
+
+// respRecords 分页返回记录结构
+type respRecords struct {
+	Records    []*respRecord `json:"records"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+// respTeamTrip 双人驾驶行程返回结构
+type respTeamTrip struct {
+	model.TeamTrip `json:",inline"`
+	Records        []model.Record `json:"records"`
+}
+
+// respOdometer 车辆里程返回结构
+type respOdometer struct {
+	Reading  *model.OdometerReading `json:"reading,omitempty"`
+	Timeline []model.OdometerEntry  `json:"timeline"`
+}
@@ -21,22 +21,208 @@ func LoadRoutes(r router.Router) {
 		Handler: offlineSyncRecords,
 		Roles:   []int{constant.ROLE_DRIVER},
 	})
+	r.Add(&router.Route{
+		Path:    "/record/status",
+		Method:  http.MethodGet,
+		Handler: getStatus,
+		Roles:   []int{constant.ROLE_DRIVER},
+	})
 	r.Add(&router.Route{
 		Path:    "/record/:id",
 		Method:  http.MethodDelete,
 		Handler: deleteLatestRecord,
 		Roles:   []int{constant.ROLE_DRIVER},
 	})
+	r.Add(&router.Route{
+		Path:    "/record/:id",
+		Method:  http.MethodPut,
+		Handler: amendRecord,
+		Roles:   []int{constant.ROLE_DRIVER, constant.ROLE_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/record/:id/history",
+		Method:  http.MethodGet,
+		Handler: getRecordHistory,
+		Roles:   []int{constant.ROLE_DRIVER, constant.ROLE_ADMIN},
+	})
 	r.Add(&router.Route{
 		Path:    "/records",
 		Method:  http.MethodGet,
 		Handler: getRecords,
 		Roles:   []int{constant.ROLE_DRIVER},
 	})
+	r.Add(&router.Route{
+		Path:    "/records/export",
+		Method:  http.MethodGet,
+		Handler: exportRecords,
+		Roles:   []int{constant.ROLE_DRIVER, constant.ROLE_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/compliance",
+		Method:  http.MethodGet,
+		Handler: getCompliance,
+		Roles:   []int{constant.ROLE_DRIVER, constant.ROLE_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/logbook",
+		Method:  http.MethodGet,
+		Handler: getLogbook,
+		Roles:   []int{constant.ROLE_DRIVER, constant.ROLE_ADMIN},
+	})
 	r.Add(&router.Route{
 		Path:    "/record/note",
 		Method:  http.MethodPost,
 		Handler: addNote,
 		Roles:   []int{constant.ROLE_DRIVER},
 	})
+	r.Add(&router.Route{
+		Path:    "/record/notes/trip",
+		Method:  http.MethodGet,
+		Handler: getTripNotes,
+		Roles:   []int{constant.ROLE_DRIVER, constant.ROLE_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/record/note/trip/:id",
+		Method:  http.MethodPut,
+		Handler: updateTripNote,
+		Roles:   []int{constant.ROLE_DRIVER, constant.ROLE_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/inspection",
+		Method:  http.MethodPost,
+		Handler: addInspection,
+		Roles:   []int{constant.ROLE_DRIVER},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/inspection/:id",
+		Method:  http.MethodDelete,
+		Handler: revokeInspection,
+		Roles:   []int{constant.ROLE_DRIVER},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/inspection/:id/accesses",
+		Method:  http.MethodGet,
+		Handler: getInspectionAccesses,
+		Roles:   []int{constant.ROLE_DRIVER},
+	})
+	// 检查令牌自带签名验证，不经过JWT中间件
+	r.Add(&router.Route{
+		Path:    "/inspection/:token",
+		Method:  http.MethodGet,
+		Handler: viewInspection,
+	})
+	r.Add(&router.Route{
+		Path:    "/records/team",
+		Method:  http.MethodPost,
+		Handler: addTeamTrip,
+		Roles:   []int{constant.ROLE_DRIVER},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/team/:id/end",
+		Method:  http.MethodPut,
+		Handler: endTeamTrip,
+		Roles:   []int{constant.ROLE_DRIVER},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/team/:id",
+		Method:  http.MethodGet,
+		Handler: getTeamTrip,
+		Roles:   []int{constant.ROLE_DRIVER, constant.ROLE_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/odometer/:id",
+		Method:  http.MethodGet,
+		Handler: getOdometer,
+		Roles:   []int{constant.ROLE_DRIVER, constant.ROLE_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/summaries",
+		Method:  http.MethodGet,
+		Handler: getSummaries,
+		Roles:   []int{constant.ROLE_DRIVER, constant.ROLE_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/summaries/rebuild",
+		Method:  http.MethodPost,
+		Handler: rebuildSummaries,
+		Roles:   []int{constant.ROLE_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/archives",
+		Method:  http.MethodGet,
+		Handler: getArchives,
+		Roles:   []int{constant.ROLE_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/archives/run",
+		Method:  http.MethodPost,
+		Handler: runRetention,
+		Roles:   []int{constant.ROLE_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/archives/restore",
+		Method:  http.MethodPost,
+		Handler: restoreArchives,
+		Roles:   []int{constant.ROLE_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/chain/verify",
+		Method:  http.MethodGet,
+		Handler: verifyChain,
+		Roles:   []int{constant.ROLE_DRIVER, constant.ROLE_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/schemes",
+		Method:  http.MethodPost,
+		Handler: addScheme,
+		Roles:   []int{constant.ROLE_ADMIN, constant.ROLE_TO_SUPER, constant.ROLE_TO_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/schemes",
+		Method:  http.MethodGet,
+		Handler: getSchemes,
+		Roles:   []int{constant.ROLE_ADMIN, constant.ROLE_TO_SUPER, constant.ROLE_TO_ADMIN, constant.ROLE_DRIVER},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/schemes/assignments",
+		Method:  http.MethodPost,
+		Handler: assignScheme,
+		Roles:   []int{constant.ROLE_ADMIN, constant.ROLE_TO_SUPER, constant.ROLE_TO_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/schemes/assignments",
+		Method:  http.MethodGet,
+		Handler: getSchemeAssignments,
+		Roles:   []int{constant.ROLE_ADMIN, constant.ROLE_TO_SUPER, constant.ROLE_TO_ADMIN, constant.ROLE_DRIVER},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/audits",
+		Method:  http.MethodGet,
+		Handler: getAuditReports,
+		Roles:   []int{constant.ROLE_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/audits/run",
+		Method:  http.MethodPost,
+		Handler: runAudit,
+		Roles:   []int{constant.ROLE_ADMIN},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/ruc/:id",
+		Method:  http.MethodGet,
+		Handler: getRUCReport,
+		Roles:   []int{constant.ROLE_ADMIN, constant.ROLE_TO_SUPER, constant.ROLE_TO_ADMIN, constant.ROLE_DRIVER},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/suggestions",
+		Method:  http.MethodGet,
+		Handler: getSuggestions,
+		Roles:   []int{constant.ROLE_DRIVER},
+	})
+	r.Add(&router.Route{
+		Path:    "/records/suggestions/accept",
+		Method:  http.MethodPost,
+		Handler: acceptSuggestion,
+		Roles:   []int{constant.ROLE_DRIVER},
+	})
 }
@@ -1,6 +1,8 @@
 package record
 
 import (
+	"time"
+
 	"github.com/chadhao/logit/config"
 	"github.com/chadhao/logit/modules/record/api"
 	"github.com/chadhao/logit/modules/record/model"
@@ -16,6 +18,8 @@ func InitModule(r router.Router, c config.Config) error {
 	// add routes
 	api.LoadRoutes(r)
 	// other initialization code
+	model.StartRetention(24 * time.Hour)
+	model.StartAudit(24 * time.Hour)
 	return nil
 }
 
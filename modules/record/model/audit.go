@@ -0,0 +1,327 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditCheck 审计检查项
+type AuditCheck string
+
+const (
+	// AUDITTYPE 相邻记录类型相同
+	AUDITTYPE AuditCheck = "type"
+	// AUDITTIME 记录与上一条记录时间重叠或顺序错误
+	AUDITTIME AuditCheck = "time"
+	// AUDITDURATION 记录时长无效或与上一条记录之间有空档
+	AUDITDURATION AuditCheck = "duration"
+	// AUDITLOCATION 记录开始位置与上一条记录结束位置不符
+	AUDITLOCATION AuditCheck = "location"
+	// AUDITMILEAGE 里程读数回退或与同一车辆上一条记录不连续
+	AUDITMILEAGE AuditCheck = "mileage"
+	// AUDITGPS 申报里程与补充计算的GPS里程不符
+	AUDITGPS AuditCheck = "gps"
+)
+
+// auditPrefix 审计生成的系统笔记前缀, 用于避免重复添加
+const auditPrefix = "audit: "
+
+// AuditIssue 审计发现的一处问题
+type AuditIssue struct {
+	RecordID     primitive.ObjectID  `bson:"recordID" json:"recordID"`
+	PrevRecordID *primitive.ObjectID `bson:"prevRecordID,omitempty" json:"prevRecordID,omitempty"`
+	Time         time.Time           `bson:"time" json:"time"`
+	Check        AuditCheck          `bson:"check" json:"check"`
+	Detail       string              `bson:"detail" json:"detail"`
+	// note 与添加记录时相同的系统笔记内容, 为空时使用审计前缀
+	note string
+}
+
+// AuditReport 一名司机的审计结果, NotesAdded为本次新添加的系统笔记数
+type AuditReport struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	DriverID   primitive.ObjectID `bson:"driverID" json:"driverID"`
+	Records    int                `bson:"records" json:"records"`
+	Issues     []AuditIssue       `bson:"issues" json:"issues"`
+	NotesAdded int                `bson:"notesAdded" json:"notesAdded"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// AuditCheckpoint 司机上次审计开始的时间, 下次审计只检查之后添加、修改或删除的记录所影响的范围
+type AuditCheckpoint struct {
+	DriverID  primitive.ObjectID `bson:"_id" json:"driverID"`
+	AuditedAt time.Time          `bson:"auditedAt" json:"auditedAt"`
+}
+
+var auditMu sync.Mutex
+
+// StartAudit 按interval定时审计全部司机的记录
+func StartAudit(interval time.Duration) {
+	auditTicker = time.NewTicker(interval)
+	go func() {
+		for range auditTicker.C {
+			if _, err := RunAudit(nil); err != nil {
+				log.Println("record audit:", err)
+			}
+		}
+	}()
+}
+
+// RunAudit 审计指定司机的记录, driverIDs为nil时审计全部司机, 只返回发现问题的司机的报告
+func RunAudit(driverIDs []primitive.ObjectID) ([]AuditReport, error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if driverIDs == nil {
+		values, err := recordCollection.Distinct(context.TODO(), "driverID", bson.M{"deletedAt": nil})
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			if id, ok := v.(primitive.ObjectID); ok {
+				driverIDs = append(driverIDs, id)
+			}
+		}
+	}
+	reports := []AuditReport{}
+	for _, driverID := range driverIDs {
+		report, err := auditDriver(driverID)
+		if err != nil {
+			return reports, err
+		}
+		if len(report.Issues) > 0 {
+			reports = append(reports, *report)
+		}
+	}
+	return reports, nil
+}
+
+// auditDriver 从上次审计的检查点开始增量检查司机的记录, 为每处问题添加系统笔记并保存报告.
+// 上次审计后添加、修改或删除的记录中最早的时间之后的记录按时间顺序重新检查, 首次审计时检查全部记录
+func auditDriver(driverID primitive.ObjectID) (*AuditReport, error) {
+	report := &AuditReport{
+		ID:        primitive.NewObjectID(),
+		DriverID:  driverID,
+		Issues:    []AuditIssue{},
+		CreatedAt: time.Now(),
+	}
+	cp := &AuditCheckpoint{DriverID: driverID}
+	err := auditCheckpointCollection.FindOne(context.TODO(), bson.M{"_id": driverID}).Decode(cp)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	from, changed, err := auditFrom(driverID, cp.AuditedAt)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		records, prev, err := auditRecords(driverID, from)
+		if err != nil {
+			return nil, err
+		}
+		report.Records = len(records)
+		for i := range records {
+			// 与trackOdometer相同, 按车辆查找上一读数, 里程可能由其他司机记录
+			var lastOdo *Record
+			if records[i].StartMileAge != nil {
+				if lastOdo, err = records[i].previousOdometer(); err != nil {
+					return nil, err
+				}
+			}
+			report.Issues = append(report.Issues, records[i].audit(prev, lastOdo)...)
+			prev = &records[i]
+		}
+	}
+
+	// 添加时行驶位置尚未上传的驾驶记录补充计算GPS里程, 使用与添加记录时相同的笔记内容
+	records, err := gpsPendingRecords(driverID, report.CreatedAt.Add(-gpsRetryWindow))
+	if err != nil {
+		return nil, err
+	}
+	for i := range records {
+		detail, err := records[i].trackGPSDistance()
+		if err != nil {
+			return nil, err
+		}
+		if detail != "" {
+			report.Issues = append(report.Issues, AuditIssue{RecordID: records[i].ID, Time: records[i].Time, Check: AUDITGPS, Detail: detail, note: detail})
+		}
+	}
+
+	for _, issue := range report.Issues {
+		added, err := addAuditNote(issue)
+		if err != nil {
+			return nil, err
+		}
+		if added {
+			report.NotesAdded++
+		}
+	}
+	if len(report.Issues) > 0 {
+		if _, err = auditCollection.InsertOne(context.TODO(), report); err != nil {
+			return nil, err
+		}
+	}
+	// 审计期间添加的记录创建时间不早于本次审计开始的时间, 下次审计时检查
+	cp.AuditedAt = report.CreatedAt
+	if _, err = auditCheckpointCollection.ReplaceOne(context.TODO(), bson.M{"_id": driverID}, cp, options.Replace().SetUpsert(true)); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// auditFrom 获取since之后添加的记录及修改或删除前的记录中最早的结束时间, changed为false时没有需要检查的记录.
+// since为空时从最早的记录开始
+func auditFrom(driverID primitive.ObjectID, since time.Time) (from time.Time, changed bool, err error) {
+	if since.IsZero() {
+		return time.Time{}, true, nil
+	}
+	r := new(Record)
+	opts := options.FindOne().SetSort(bson.D{{Key: "time", Value: 1}})
+	err = recordCollection.FindOne(context.TODO(), bson.M{"driverID": driverID, "createdAt": bson.M{"$gte": since}}, opts).Decode(r)
+	switch {
+	case err == nil:
+		from, changed = r.Time, true
+	case err != mongo.ErrNoDocuments:
+		return
+	}
+	// 修改或删除后, 原记录之后的记录与上一条记录的衔接可能改变
+	rv := new(RecordVersion)
+	opts = options.FindOne().SetSort(bson.D{{Key: "record.time", Value: 1}})
+	err = historyCollection.FindOne(context.TODO(), bson.M{"record.driverID": driverID, "createdAt": bson.M{"$gte": since}}, opts).Decode(rv)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return from, changed, nil
+	case err != nil:
+		return
+	}
+	if !changed || rv.Record.Time.Before(from) {
+		from = rv.Record.Time
+	}
+	return from, true, nil
+}
+
+// auditRecords 获取结束时间不早于from的未删除记录, 及之前的上一条记录, 不存在上一条记录时prev为nil
+func auditRecords(driverID primitive.ObjectID, from time.Time) (records []Record, prev *Record, err error) {
+	filter := bson.M{"driverID": driverID, "deletedAt": nil, "time": bson.M{"$gte": from}}
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: 1}})
+	cursor, err := recordCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	records = []Record{}
+	if err = cursor.All(context.TODO(), &records); err != nil {
+		return nil, nil, err
+	}
+	if from.IsZero() {
+		return records, nil, nil
+	}
+	prev = new(Record)
+	filter["time"] = bson.M{"$lt": from}
+	err = recordCollection.FindOne(context.TODO(), filter, options.FindOne().SetSort(bson.D{{Key: "time", Value: -1}})).Decode(prev)
+	if err == mongo.ErrNoDocuments {
+		return records, nil, nil
+	}
+	return records, prev, err
+}
+
+// gpsPendingRecords 获取结束时间不早于from且尚未计算GPS里程的驾驶记录
+func gpsPendingRecords(driverID primitive.ObjectID, from time.Time) ([]Record, error) {
+	filter := bson.M{
+		"driverID":    driverID,
+		"deletedAt":   nil,
+		"type":        DRIVING,
+		"gpsDistance": nil,
+		"time":        bson.M{"$gte": from},
+	}
+	cursor, err := recordCollection.Find(context.TODO(), filter)
+	if err != nil {
+		return nil, err
+	}
+	records := []Record{}
+	if err = cursor.All(context.TODO(), &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// audit 检查记录本身及其与上一条记录和同一车辆上一条里程记录的衔接
+func (r *Record) audit(prev, lastOdo *Record) []AuditIssue {
+	issues := []AuditIssue{}
+	add := func(check AuditCheck, other *Record, format string, a ...interface{}) {
+		issue := AuditIssue{RecordID: r.ID, Time: r.Time, Check: check, Detail: fmt.Sprintf(format, a...)}
+		if other != nil {
+			issue.PrevRecordID = &other.ID
+		}
+		issues = append(issues, issue)
+	}
+
+	if r.Duration <= 0 {
+		add(AUDITDURATION, nil, "duration %s is not positive", r.Duration)
+	}
+	if r.StartMileAge != nil && r.EndMileAge != nil && *r.EndMileAge < *r.StartMileAge-OdometerTolerance {
+		add(AUDITMILEAGE, nil, "end distance %.1f km is less than start distance %.1f km", *r.EndMileAge, *r.StartMileAge)
+	}
+	if prev != nil {
+		if prev.Type == r.Type {
+			add(AUDITTYPE, prev, "same type %s as last record", r.Type)
+		}
+		// 与beforeAdd相同, 允许10秒误差
+		switch diff := r.Time.Add(-r.Duration).Sub(prev.Time); {
+		case diff < -10*time.Second:
+			add(AUDITTIME, prev, "starts %s before last record ends", -diff)
+		case diff > 10*time.Second:
+			add(AUDITDURATION, prev, "starts %s after last record ends", diff)
+		}
+		if !r.StartLocation.equal(&prev.EndLocation) {
+			add(AUDITLOCATION, prev, "start location %q does not match last end location %q", r.StartLocation.Address, prev.EndLocation.Address)
+		}
+	}
+	if lastOdo != nil && r.StartMileAge != nil {
+		// 使用与trackOdometer相同的笔记内容, 同一异常不会重复添加
+		if comment := r.previousOdometerComment(lastOdo); comment != "" {
+			add(AUDITMILEAGE, lastOdo, "%s", comment)
+			issues[len(issues)-1].note = comment
+		}
+	}
+	return issues
+}
+
+// addAuditNote 为问题添加系统笔记, 已有相同笔记时跳过
+func addAuditNote(issue AuditIssue) (bool, error) {
+	comment := issue.note
+	if comment == "" {
+		comment = auditPrefix + string(issue.Check) + ": " + issue.Detail
+	}
+	r := &Record{ID: issue.RecordID}
+	return r.addSystemNoteOnce(comment)
+}
+
+// GetAuditReports 获取时间段内的审计报告, driverID为空时获取全部司机的报告
+func GetAuditReports(driverID primitive.ObjectID, from, to time.Time) ([]AuditReport, error) {
+	if from.After(to) {
+		return nil, errors.New("times order is wrong")
+	}
+	filter := bson.M{"createdAt": bson.M{"$gte": from, "$lte": to}}
+	if !driverID.IsZero() {
+		filter["driverID"] = driverID
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := auditCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	reports := []AuditReport{}
+	if err = cursor.All(context.TODO(), &reports); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
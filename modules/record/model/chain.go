@@ -0,0 +1,441 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// chainRetries 并发写入时更新链头的重试次数
+const chainRetries = 10
+
+// ChainLink 哈希链节点, 每条记录和笔记按写入顺序链接到该司机的上一条
+type ChainLink struct {
+	Seq      int64  `bson:"seq,omitempty" json:"seq,omitempty" valid:"-"`
+	PrevHash string `bson:"prevHash,omitempty" json:"prevHash,omitempty" valid:"-"`
+	Hash     string `bson:"hash,omitempty" json:"hash,omitempty" valid:"-"`
+}
+
+// ChainHead 司机哈希链的最新节点, StartedAt为建立链的时间, 之后写入的记录和笔记都应已链接
+type ChainHead struct {
+	DriverID  primitive.ObjectID `bson:"_id" json:"driverID"`
+	Seq       int64              `bson:"seq" json:"seq"`
+	Hash      string             `bson:"hash" json:"hash"`
+	StartedAt time.Time          `bson:"startedAt,omitempty" json:"startedAt,omitempty"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// String 链头的文本形式, 用于导出文件
+func (ch *ChainHead) String() string {
+	return fmt.Sprintf("%d:%s", ch.Seq, ch.Hash)
+}
+
+// chainExcluded 不参与哈希计算的字段, GPS里程由系统计算并可能在写入后补充
+var chainExcluded = map[string]bool{
+	"seq":         true,
+	"prevHash":    true,
+	"hash":        true,
+	"gpsDistance": true,
+}
+
+// chainContent 去掉链字段后的文档内容
+func chainContent(doc bson.Raw) ([]byte, error) {
+	elems, err := doc.Elements()
+	if err != nil {
+		return nil, err
+	}
+	d := bson.D{}
+	for _, e := range elems {
+		if !chainExcluded[e.Key()] {
+			d = append(d, bson.E{Key: e.Key(), Value: e.Value()})
+		}
+	}
+	return bson.Marshal(d)
+}
+
+// chainHash 计算节点哈希
+func chainHash(seq int64, prevHash string, content []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d|%s|", seq, prevHash)
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// GetChainHead 获取司机的链头, 尚未建立链时返回seq为0的链头
+func GetChainHead(driverID primitive.ObjectID) (*ChainHead, error) {
+	ch := &ChainHead{DriverID: driverID}
+	err := chainHeadCollection.FindOne(context.TODO(), bson.M{"_id": driverID}).Decode(ch)
+	if err == mongo.ErrNoDocuments {
+		return ch, nil
+	}
+	return ch, err
+}
+
+// moveChainHead 将链头从head移动到link, 链头已被并发修改时返回false
+func moveChainHead(head *ChainHead, link *ChainLink) (bool, error) {
+	next := &ChainHead{DriverID: head.DriverID, Seq: link.Seq, Hash: link.Hash, StartedAt: head.StartedAt, UpdatedAt: time.Now()}
+	if head.Seq == 0 {
+		next.StartedAt = next.UpdatedAt
+		_, err := chainHeadCollection.InsertOne(context.TODO(), next)
+		if we, ok := err.(mongo.WriteException); ok && len(we.WriteErrors) > 0 && we.WriteErrors[0].Code == duplicateKeyCode {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	res, err := chainHeadCollection.ReplaceOne(context.TODO(), bson.M{"_id": head.DriverID, "seq": head.Seq}, next)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// chainDocument 将已写入的文档追加到司机的哈希链上.
+// 先在文档上写入节点再移动链头, 链头未能移动时清除文档上的节点, 文档保持未链接状态
+func chainDocument(collection *mongo.Collection, id, driverID primitive.ObjectID) (*ChainLink, error) {
+	doc, err := collection.FindOne(context.TODO(), bson.M{"_id": id}).DecodeBytes()
+	if err != nil {
+		return nil, err
+	}
+	content, err := chainContent(doc)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < chainRetries; i++ {
+		head, err := GetChainHead(driverID)
+		if err != nil {
+			return nil, err
+		}
+		link := &ChainLink{Seq: head.Seq + 1, PrevHash: head.Hash}
+		link.Hash = chainHash(link.Seq, link.PrevHash, content)
+		update := bson.M{"$set": bson.M{"seq": link.Seq, "prevHash": link.PrevHash, "hash": link.Hash}}
+		if _, err = collection.UpdateOne(context.TODO(), bson.M{"_id": id}, update); err != nil {
+			return nil, err
+		}
+		moved, err := moveChainHead(head, link)
+		if err != nil {
+			unchainDocument(collection, id)
+			return nil, err
+		}
+		if moved {
+			return link, nil
+		}
+	}
+	unchainDocument(collection, id)
+	return nil, errors.New("chain head is busy")
+}
+
+// unchainDocument 清除文档上未能写入链头的节点
+func unchainDocument(collection *mongo.Collection, id primitive.ObjectID) {
+	update := bson.M{"$unset": bson.M{"seq": "", "prevHash": "", "hash": ""}}
+	if _, err := collection.UpdateOne(context.TODO(), bson.M{"_id": id}, update); err != nil {
+		log.Println("record unchain:", id.Hex(), err)
+	}
+}
+
+// discardUnchained 删除未能链接到哈希链的新文档, 使添加整体失败
+func discardUnchained(collection *mongo.Collection, ids ...primitive.ObjectID) {
+	filter := bson.M{"_id": bson.M{"$in": ids}, "seq": nil}
+	if _, err := collection.DeleteMany(context.TODO(), filter); err != nil {
+		log.Println("record discard unchained:", err)
+	}
+}
+
+// chain 记录写入后链接到哈希链
+func (r *Record) chain() error {
+	link, err := chainDocument(recordCollection, r.ID, r.DriverID)
+	if err != nil {
+		return err
+	}
+	r.ChainLink = *link
+	return nil
+}
+
+// chain 笔记写入后链接到所属记录司机的哈希链
+func (n *Note) chain() error {
+	r, err := GetRecord(n.RecordID)
+	if err != nil {
+		return err
+	}
+	link, err := chainDocument(noteCollection, n.ID, r.DriverID)
+	if err != nil {
+		return err
+	}
+	n.ChainLink = *link
+	return nil
+}
+
+// chainOrDiscard 新笔记写入后链接到哈希链, 链接失败时删除笔记, 使添加整体失败
+func (n *Note) chainOrDiscard() error {
+	if err := n.chain(); err != nil {
+		discardUnchained(noteCollection, n.ID)
+		return err
+	}
+	return nil
+}
+
+// NoteVersion 行程笔记修改前的历史版本, 保留原始文档以便验证哈希链
+type NoteVersion struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	NoteID    primitive.ObjectID `bson:"noteID" json:"noteID"`
+	RecordID  primitive.ObjectID `bson:"recordID" json:"recordID"`
+	Note      bson.Raw           `bson:"note" json:"-"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// addNoteVersion 保存笔记修改前的原始文档
+func addNoteVersion(noteID primitive.ObjectID) (*NoteVersion, error) {
+	doc, err := noteCollection.FindOne(context.TODO(), bson.M{"_id": noteID}).DecodeBytes()
+	if err != nil {
+		return nil, err
+	}
+	nv := &NoteVersion{
+		ID:        primitive.NewObjectID(),
+		NoteID:    noteID,
+		RecordID:  doc.Lookup("recordID").ObjectID(),
+		Note:      doc,
+		CreatedAt: time.Now(),
+	}
+	if _, err = noteHistoryCollection.InsertOne(context.TODO(), nv); err != nil {
+		return nil, err
+	}
+	return nv, nil
+}
+
+// revert 修改后的笔记未能链接时恢复为修改前的文档并删除该版本
+func (nv *NoteVersion) revert() {
+	if _, err := noteCollection.ReplaceOne(context.TODO(), bson.M{"_id": nv.NoteID}, nv.Note); err != nil {
+		log.Println("note revert:", nv.NoteID.Hex(), err)
+		return
+	}
+	if _, err := noteHistoryCollection.DeleteOne(context.TODO(), bson.M{"_id": nv.ID}); err != nil {
+		log.Println("note revert:", nv.NoteID.Hex(), err)
+	}
+}
+
+// ChainBreak 哈希链中的一处断裂
+type ChainBreak struct {
+	Seq    int64              `json:"seq"`
+	Kind   string             `json:"kind,omitempty"`
+	ID     primitive.ObjectID `json:"id,omitempty"`
+	Reason string             `json:"reason"`
+}
+
+// ChainReport 哈希链验证结果, From之前的节点可能已被归档, Archived为按归档清单衔接的节点数
+type ChainReport struct {
+	DriverID primitive.ObjectID `json:"driverID"`
+	Head     *ChainHead         `json:"head"`
+	From     int64              `json:"from"`
+	Entries  int                `json:"entries"`
+	Archived int64              `json:"archived"`
+	Valid    bool               `json:"valid"`
+	Breaks   []ChainBreak       `json:"breaks"`
+}
+
+// ChainRange 已归档的一段连续节点, PrevHash为第一个节点的上一节点哈希, Hash为最后一个节点的哈希
+type ChainRange struct {
+	From     int64  `bson:"from" json:"from"`
+	To       int64  `bson:"to" json:"to"`
+	PrevHash string `bson:"prevHash" json:"prevHash"`
+	Hash     string `bson:"hash" json:"hash"`
+}
+
+// chainEntry 验证时的一个节点, 归档的连续节点合并为一个last大于Seq的节点, 未链接的文档Seq为0
+type chainEntry struct {
+	ChainLink
+	kind      string
+	id        primitive.ObjectID
+	content   []byte
+	createdAt time.Time
+	last      int64
+	archived  bool
+}
+
+// newChainEntry 从原始文档构造节点
+func newChainEntry(kind string, doc bson.Raw) (*chainEntry, error) {
+	e := &chainEntry{kind: kind}
+	if err := bson.Unmarshal(doc, &e.ChainLink); err != nil {
+		return nil, err
+	}
+	e.id, _ = doc.Lookup("_id").ObjectIDOK()
+	e.createdAt, _ = doc.Lookup("createdAt").TimeOK()
+	e.last = e.Seq
+	content, err := chainContent(doc)
+	if err != nil {
+		return nil, err
+	}
+	e.content = content
+	return e, nil
+}
+
+// chainEntries 从记录、笔记及其历史版本的原始文档构造节点, 按seq排序, 未链接的文档排在最前
+func (data archiveData) chainEntries() ([]*chainEntry, error) {
+	entries := []*chainEntry{}
+	for _, kind := range []archiveKind{archiveRecord, archiveNote, archiveVersion, archiveNoteVersion} {
+		for _, doc := range data[kind] {
+			switch kind {
+			case archiveVersion:
+				doc = doc.Lookup("record").Document()
+			case archiveNoteVersion:
+				doc = doc.Lookup("note").Document()
+			}
+			e, err := newChainEntry(string(kind), doc)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].Seq < entries[b].Seq
+	})
+	return entries, nil
+}
+
+// chainRanges 将排序后的已链接节点合并为连续的区间
+func chainRanges(entries []*chainEntry) []ChainRange {
+	ranges := []ChainRange{}
+	for _, e := range entries {
+		if e.Seq == 0 {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1].To+1 == e.Seq {
+			ranges[n-1].To, ranges[n-1].Hash = e.Seq, e.Hash
+			continue
+		}
+		ranges = append(ranges, ChainRange{From: e.Seq, To: e.Seq, PrevHash: e.PrevHash, Hash: e.Hash})
+	}
+	return ranges
+}
+
+// driverChainEntries 获取司机哈希链上现存的全部节点, 包括记录、笔记及其历史版本
+func driverChainEntries(driverID primitive.ObjectID) ([]*chainEntry, error) {
+	data := archiveData{}
+	var err error
+	if data[archiveRecord], err = findRaw(recordCollection, bson.M{"driverID": driverID}); err != nil {
+		return nil, err
+	}
+	recordIDs := rawIDs(data[archiveRecord])
+	if len(recordIDs) > 0 {
+		filter := bson.M{"recordID": bson.M{"$in": recordIDs}}
+		if data[archiveNote], err = findRaw(noteCollection, filter); err != nil {
+			return nil, err
+		}
+		if data[archiveVersion], err = findRaw(historyCollection, filter); err != nil {
+			return nil, err
+		}
+		if data[archiveNoteVersion], err = findRaw(noteHistoryCollection, filter); err != nil {
+			return nil, err
+		}
+	}
+	return data.chainEntries()
+}
+
+// archivedChainEntries 获取司机已归档且未恢复的节点区间
+func archivedChainEntries(driverID primitive.ObjectID) ([]*chainEntry, error) {
+	manifests, err := findManifests(bson.M{"driverID": driverID, "restoredUntil": nil})
+	if err != nil {
+		return nil, err
+	}
+	entries := []*chainEntry{}
+	for _, m := range manifests {
+		for _, r := range m.Chain {
+			entries = append(entries, &chainEntry{
+				ChainLink: ChainLink{Seq: r.From, PrevHash: r.PrevHash, Hash: r.Hash},
+				kind:      "archive",
+				id:        m.ID,
+				last:      r.To,
+				archived:  true,
+			})
+		}
+	}
+	return entries, nil
+}
+
+// VerifyChain 按顺序遍历司机的哈希链并报告所有断裂
+func VerifyChain(driverID primitive.ObjectID) (*ChainReport, error) {
+	head, err := GetChainHead(driverID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := driverChainEntries(driverID)
+	if err != nil {
+		return nil, err
+	}
+	archived, err := archivedChainEntries(driverID)
+	if err != nil {
+		return nil, err
+	}
+	return verifyChain(head, entries, archived), nil
+}
+
+// verifyChain 验证现存节点及归档区间, 建立链之后写入却未链接的文档也视为断裂
+func verifyChain(head *ChainHead, entries, archived []*chainEntry) *ChainReport {
+	report := &ChainReport{DriverID: head.DriverID, Head: head, Breaks: []ChainBreak{}}
+
+	// 旧链头没有建立时间, 以最早链接的文档时间为准
+	started := head.StartedAt
+	chained := []*chainEntry{}
+	for _, e := range entries {
+		if e.Seq == 0 {
+			continue
+		}
+		chained = append(chained, e)
+		if head.StartedAt.IsZero() && (started.IsZero() || e.createdAt.Before(started)) {
+			started = e.createdAt
+		}
+	}
+	for _, e := range entries {
+		if e.Seq == 0 && !started.IsZero() && !e.createdAt.Before(started) {
+			report.Breaks = append(report.Breaks, ChainBreak{Kind: e.kind, ID: e.id, Reason: "unchained entry"})
+		}
+	}
+	report.Entries = len(chained)
+
+	// 归档的区间只验证首尾哈希的衔接
+	for _, e := range archived {
+		report.Archived += e.last - e.Seq + 1
+	}
+	chained = append(chained, archived...)
+	sort.SliceStable(chained, func(a, b int) bool {
+		return chained[a].Seq < chained[b].Seq
+	})
+
+	var prev *chainEntry
+	for _, e := range chained {
+		switch {
+		case prev == nil:
+			report.From = e.Seq
+			// 从第一个节点开始时必须没有上一节点
+			if e.Seq == 1 && e.PrevHash != "" {
+				report.Breaks = append(report.Breaks, ChainBreak{Seq: e.Seq, Kind: e.kind, ID: e.id, Reason: "previous hash mismatch"})
+			}
+		case e.Seq <= prev.last:
+			report.Breaks = append(report.Breaks, ChainBreak{Seq: e.Seq, Kind: e.kind, ID: e.id, Reason: "duplicate sequence"})
+		case e.Seq > prev.last+1:
+			report.Breaks = append(report.Breaks, ChainBreak{Seq: prev.last + 1, Reason: "missing entry"})
+		case e.PrevHash != prev.Hash:
+			report.Breaks = append(report.Breaks, ChainBreak{Seq: e.Seq, Kind: e.kind, ID: e.id, Reason: "previous hash mismatch"})
+		}
+		if !e.archived && chainHash(e.Seq, e.PrevHash, e.content) != e.Hash {
+			report.Breaks = append(report.Breaks, ChainBreak{Seq: e.Seq, Kind: e.kind, ID: e.id, Reason: "content hash mismatch"})
+		}
+		prev = e
+	}
+	switch {
+	case prev == nil && head.Seq > 0:
+		report.Breaks = append(report.Breaks, ChainBreak{Seq: head.Seq, Reason: "missing entry"})
+	case prev != nil && (prev.last != head.Seq || prev.Hash != head.Hash):
+		report.Breaks = append(report.Breaks, ChainBreak{Seq: head.Seq, Reason: "head mismatch"})
+	}
+	report.Valid = len(report.Breaks) == 0
+	return report
+}
@@ -0,0 +1,198 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// chainDoc 构造已链接的记录文档, seq为0时不链接
+func chainDoc(t *testing.T, seq int64, prevHash string, createdAt time.Time, comment string) bson.Raw {
+	d := bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "createdAt", Value: createdAt}, {Key: "comment", Value: comment}}
+	if seq > 0 {
+		raw, err := bson.Marshal(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := chainContent(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d = append(d, bson.E{Key: "seq", Value: seq}, bson.E{Key: "prevHash", Value: prevHash}, bson.E{Key: "hash", Value: chainHash(seq, prevHash, content)})
+	}
+	raw, err := bson.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// chainOf 构造从seq 1开始的n个依次链接的节点及对应的链头
+func chainOf(t *testing.T, n int) ([]*chainEntry, *ChainHead) {
+	entries := []*chainEntry{}
+	head := &ChainHead{StartedAt: t0}
+	for i := 1; i <= n; i++ {
+		doc := chainDoc(t, int64(i), head.Hash, t0.Add(time.Duration(i)*time.Minute), "entry")
+		e, err := newChainEntry(string(archiveRecord), doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+		head.Seq, head.Hash = e.Seq, e.Hash
+	}
+	return entries, head
+}
+
+func breakReasons(report *ChainReport) []string {
+	reasons := []string{}
+	for _, b := range report.Breaks {
+		reasons = append(reasons, b.Reason)
+	}
+	return reasons
+}
+
+func TestChainContent(t *testing.T) {
+	doc := chainDoc(t, 3, "prev", t0, "entry")
+	content, err := chainContent(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"seq", "prevHash", "hash"} {
+		if _, err := bson.Raw(content).LookupErr(key); err == nil {
+			t.Errorf("chainContent contains %s", key)
+		}
+	}
+
+	withDeleted := append(bson.D{}, bson.E{Key: "comment", Value: "entry"}, bson.E{Key: "deletedAt", Value: t0})
+	raw, _ := bson.Marshal(withDeleted)
+	if content, err = chainContent(raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bson.Raw(content).LookupErr("deletedAt"); err != nil {
+		t.Error("chainContent excludes deletedAt")
+	}
+
+	if chainHash(1, "", content) == chainHash(2, "", content) {
+		t.Error("chainHash ignores seq")
+	}
+	if chainHash(1, "", content) == chainHash(1, "prev", content) {
+		t.Error("chainHash ignores previous hash")
+	}
+}
+
+func TestChainRanges(t *testing.T) {
+	entries, _ := chainOf(t, 5)
+	unchained, _ := newChainEntry(string(archiveNote), chainDoc(t, 0, "", t0, "unchained"))
+	// 去掉seq 3, 剩余节点合并为两个区间
+	ranges := chainRanges(append([]*chainEntry{unchained}, entries[0], entries[1], entries[3], entries[4]))
+	want := []ChainRange{
+		{From: 1, To: 2, PrevHash: "", Hash: entries[1].Hash},
+		{From: 4, To: 5, PrevHash: entries[2].Hash, Hash: entries[4].Hash},
+	}
+	if len(ranges) != len(want) {
+		t.Fatalf("chainRanges = %+v, want %+v", ranges, want)
+	}
+	for i := range want {
+		if ranges[i] != want[i] {
+			t.Errorf("range %d = %+v, want %+v", i, ranges[i], want[i])
+		}
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func() (*ChainHead, []*chainEntry, []*chainEntry)
+		want  []string
+	}{
+		{"valid", func() (*ChainHead, []*chainEntry, []*chainEntry) {
+			entries, head := chainOf(t, 4)
+			return head, entries, nil
+		}, []string{}},
+		{"empty", func() (*ChainHead, []*chainEntry, []*chainEntry) {
+			return &ChainHead{}, nil, nil
+		}, []string{}},
+		{"content tampered", func() (*ChainHead, []*chainEntry, []*chainEntry) {
+			entries, head := chainOf(t, 3)
+			entries[1].content = append([]byte{}, entries[0].content...)
+			return head, entries, nil
+		}, []string{"content hash mismatch"}},
+		{"entry missing", func() (*ChainHead, []*chainEntry, []*chainEntry) {
+			entries, head := chainOf(t, 3)
+			return head, []*chainEntry{entries[0], entries[2]}, nil
+		}, []string{"missing entry"}},
+		{"all entries missing", func() (*ChainHead, []*chainEntry, []*chainEntry) {
+			_, head := chainOf(t, 3)
+			return head, nil, nil
+		}, []string{"missing entry"}},
+		{"duplicate sequence", func() (*ChainHead, []*chainEntry, []*chainEntry) {
+			entries, head := chainOf(t, 3)
+			dup := *entries[1]
+			return head, append(entries, &dup), nil
+		}, []string{"duplicate sequence"}},
+		{"previous hash replaced", func() (*ChainHead, []*chainEntry, []*chainEntry) {
+			entries, head := chainOf(t, 3)
+			entries[2].PrevHash = "forged"
+			return head, entries, nil
+		}, []string{"previous hash mismatch", "content hash mismatch"}},
+		{"head behind", func() (*ChainHead, []*chainEntry, []*chainEntry) {
+			entries, head := chainOf(t, 3)
+			head.Seq, head.Hash = entries[1].Seq, entries[1].Hash
+			return head, entries, nil
+		}, []string{"head mismatch"}},
+		{"unchained after start", func() (*ChainHead, []*chainEntry, []*chainEntry) {
+			entries, head := chainOf(t, 2)
+			e, _ := newChainEntry(string(archiveRecord), chainDoc(t, 0, "", t0.Add(time.Hour), "unchained"))
+			return head, append([]*chainEntry{e}, entries...), nil
+		}, []string{"unchained entry"}},
+		{"unchained before start", func() (*ChainHead, []*chainEntry, []*chainEntry) {
+			entries, head := chainOf(t, 2)
+			e, _ := newChainEntry(string(archiveRecord), chainDoc(t, 0, "", t0.Add(-time.Hour), "legacy"))
+			return head, append([]*chainEntry{e}, entries...), nil
+		}, []string{}},
+		{"unchained after legacy head", func() (*ChainHead, []*chainEntry, []*chainEntry) {
+			// 旧链头没有建立时间时以最早链接的节点为准
+			entries, head := chainOf(t, 2)
+			head.StartedAt = time.Time{}
+			legacy, _ := newChainEntry(string(archiveRecord), chainDoc(t, 0, "", t0, "legacy"))
+			later, _ := newChainEntry(string(archiveRecord), chainDoc(t, 0, "", t0.Add(time.Hour), "unchained"))
+			return head, append([]*chainEntry{legacy, later}, entries...), nil
+		}, []string{"unchained entry"}},
+		{"archived range", func() (*ChainHead, []*chainEntry, []*chainEntry) {
+			entries, head := chainOf(t, 5)
+			r := chainRanges(entries[:3])[0]
+			archived := &chainEntry{ChainLink: ChainLink{Seq: r.From, PrevHash: r.PrevHash, Hash: r.Hash}, last: r.To, archived: true}
+			return head, entries[3:], []*chainEntry{archived}
+		}, []string{}},
+		{"archived range tampered", func() (*ChainHead, []*chainEntry, []*chainEntry) {
+			entries, head := chainOf(t, 5)
+			r := chainRanges(entries[:3])[0]
+			archived := &chainEntry{ChainLink: ChainLink{Seq: r.From, PrevHash: r.PrevHash, Hash: "forged"}, last: r.To, archived: true}
+			return head, entries[3:], []*chainEntry{archived}
+		}, []string{"previous hash mismatch"}},
+		{"starting after pruned entries", func() (*ChainHead, []*chainEntry, []*chainEntry) {
+			entries, head := chainOf(t, 5)
+			return head, entries[3:], nil
+		}, []string{}},
+	}
+	for _, tt := range tests {
+		head, entries, archived := tt.build()
+		report := verifyChain(head, entries, archived)
+		reasons := breakReasons(report)
+		if len(reasons) != len(tt.want) {
+			t.Errorf("%s: breaks = %v, want %v", tt.name, reasons, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if reasons[i] != tt.want[i] {
+				t.Errorf("%s: breaks = %v, want %v", tt.name, reasons, tt.want)
+				break
+			}
+		}
+		if report.Valid != (len(tt.want) == 0) {
+			t.Errorf("%s: valid = %v", tt.name, report.Valid)
+		}
+	}
+}
@@ -5,17 +5,37 @@ import (
 	"fmt"
 	"time"
 
+	userApi "github.com/chadhao/logit/modules/user/api"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
-	mgoClient        *mongo.Client
-	db               *mongo.Database
-	recordCollection *mongo.Collection
-	noteCollection   *mongo.Collection
-	config           map[string]string
+	mgoClient                  *mongo.Client
+	db                         *mongo.Database
+	recordCollection           *mongo.Collection
+	noteCollection             *mongo.Collection
+	historyCollection          *mongo.Collection
+	inspectionCollection       *mongo.Collection
+	inspectionAccessCollection *mongo.Collection
+	teamTripCollection         *mongo.Collection
+	odometerCollection         *mongo.Collection
+	summaryCollection          *mongo.Collection
+	manifestCollection         *mongo.Collection
+	chainHeadCollection        *mongo.Collection
+	noteHistoryCollection      *mongo.Collection
+	schemeCollection           *mongo.Collection
+	schemeAssignmentCollection *mongo.Collection
+	auditCollection            *mongo.Collection
+	auditCheckpointCollection  *mongo.Collection
+	migrationCollection        *mongo.Collection
+	config                     map[string]string
+	loc                        *time.Location
+	archiveStore               ArchiveStore
+	retentionTicker            *time.Ticker
+	auditTicker                *time.Ticker
 )
 
 func connect() (err error) {
@@ -34,6 +54,20 @@ func connect() (err error) {
 	db = mgoClient.Database(database)
 	recordCollection = db.Collection("record")
 	noteCollection = db.Collection("note")
+	historyCollection = db.Collection("record_history")
+	inspectionCollection = db.Collection("inspection")
+	inspectionAccessCollection = db.Collection("inspection_access")
+	teamTripCollection = db.Collection("team_trip")
+	odometerCollection = db.Collection("vehicle_odometer")
+	summaryCollection = db.Collection("summary")
+	manifestCollection = db.Collection("archive_manifest")
+	chainHeadCollection = db.Collection("chain_head")
+	noteHistoryCollection = db.Collection("note_history")
+	schemeCollection = db.Collection("scheme")
+	schemeAssignmentCollection = db.Collection("scheme_assignment")
+	auditCollection = db.Collection("audit_report")
+	auditCheckpointCollection = db.Collection("audit_checkpoint")
+	migrationCollection = db.Collection("migration")
 	if _, err = recordCollection.Indexes().CreateOne(
 		context.Background(),
 		mongo.IndexModel{
@@ -44,23 +78,149 @@ func connect() (err error) {
 	); err != nil {
 		return
 	}
+	if _, err = recordCollection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "driverID", Value: 1}, {Key: "time", Value: 1}},
+		},
+	); err != nil {
+		return
+	}
+	if _, err = recordCollection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "driverID", Value: 1}, {Key: "clientID", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"clientID": bson.M{"$exists": true}}),
+		},
+	); err != nil {
+		return
+	}
+	if _, err = recordCollection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "vehicleID", Value: 1}, {Key: "time", Value: 1}},
+		},
+	); err != nil {
+		return
+	}
+	if _, err = noteCollection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys: bson.M{
+				"recordID": 1,
+			},
+		},
+	); err != nil {
+		return
+	}
+	if _, err = summaryCollection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "driverID", Value: 1}, {Key: "period", Value: 1}, {Key: "start", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	); err != nil {
+		return
+	}
+	if _, err = noteHistoryCollection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys: bson.M{
+				"recordID": 1,
+			},
+		},
+	); err != nil {
+		return
+	}
+	if _, err = schemeAssignmentCollection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "driverID", Value: 1}, {Key: "from", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	); err != nil {
+		return
+	}
+	if _, err = auditCollection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "driverID", Value: 1}, {Key: "createdAt", Value: 1}},
+		},
+	); err != nil {
+		return
+	}
+	if _, err = manifestCollection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "driverID", Value: 1}, {Key: "from", Value: 1}},
+		},
+	); err != nil {
+		return
+	}
+	if _, err = historyCollection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "recordID", Value: 1}, {Key: "version", Value: 1}},
+		},
+	); err != nil {
+		return
+	}
+	if _, err = historyCollection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "record.driverID", Value: 1}, {Key: "createdAt", Value: 1}},
+		},
+	); err != nil {
+		return
+	}
 	return
 }
 
 // New 创建数据库连接并传入config
-func New(c map[string]string) error {
+func New(c map[string]string) (err error) {
 	config = c
-
-	if err := connect(); err != nil {
-		return err
+	loc, err = time.LoadLocation(config["record.time.location"])
+	if err != nil {
+		return
+	}
+	if archiveStore, err = newArchiveStore(); err != nil {
+		return
 	}
+	if err = connect(); err != nil {
+		return
+	}
+	if err = runMigration("work_records", migrateWorkRecords); err != nil {
+		return
+	}
+	err = runMigration("deleted_records", migrateDeletedRecords)
+	return
+}
 
-	return nil
+// TimeLocation 记录所使用的本地时区
+func TimeLocation() *time.Location {
+	return loc
+}
+
+// DriverLocation 司机的本地时区, 司机未设置时使用默认时区
+func DriverLocation(driverID primitive.ObjectID) *time.Location {
+	l, err := userApi.DriverLocation(driverID)
+	if err != nil || l == nil {
+		return loc
+	}
+	return l
 }
 
 // Close 关闭
 func Close() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	if retentionTicker != nil {
+		retentionTicker.Stop()
+	}
+	if auditTicker != nil {
+		auditTicker.Stop()
+	}
 	mgoClient.Disconnect(ctx)
 }
@@ -0,0 +1,431 @@
+package model
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Rule 工时规则
+type Rule string
+
+const (
+	// CONTINUOUSWORK 连续工作不得超过5.5小时，需休息至少30分钟, 替代方案可另行规定
+	CONTINUOUSWORK Rule = "continuous_work"
+	// DAILYWORK 一个工作日内工作不得超过13小时, 替代方案可另行规定
+	DAILYWORK Rule = "daily_work"
+	// DAILYREST 一个工作日内需连续休息至少10小时, 替代方案可另行规定
+	DAILYREST Rule = "daily_rest"
+	// CUMULATIVEWORK 累计工作不得超过70小时，需连续休息至少24小时, 替代方案可另行规定
+	CUMULATIVEWORK Rule = "cumulative_work"
+)
+
+// ComplianceLookback 合规计算时每次向前追溯的时长, 直到找到累计工作重置所需的连续休息
+const ComplianceLookback = 14 * 24 * time.Hour
+
+// Violation 违规记录, 休息类规则的Overrun为休息不足的时长, Scheme为违规时适用的方案名称
+type Violation struct {
+	Rule    Rule          `json:"rule"`
+	Scheme  string        `json:"scheme"`
+	Start   time.Time     `json:"start"`
+	End     time.Time     `json:"end"`
+	Overrun time.Duration `json:"overrun"`
+}
+
+func (t HrTime) duration() time.Duration {
+	return time.Duration(t.getHrs() * float64(time.Hour))
+}
+
+// IsWork 记录类型是否计入工作时间
+func (t Type) IsWork() bool {
+	return t == DRIVING || t == OTHERWORK || t == WORK
+}
+
+// period 时间轴上的一段工作或休息, Driving表示驾驶, Moving表示双人驾驶时在行驶车辆中的休息
+type period struct {
+	Start   time.Time
+	End     time.Time
+	Work    bool
+	Driving bool
+	Moving  bool
+}
+
+func (p period) length() time.Duration {
+	return p.End.Sub(p.Start)
+}
+
+// timeline 将记录按时间排序转换为连续的时间轴, 记录之间的空档视为休息
+func (rs Records) timeline() []period {
+	sorted := make(Records, 0, len(rs))
+	for _, r := range rs {
+		if r.DeletedAt == nil {
+			sorted = append(sorted, r)
+		}
+	}
+	sort.Slice(sorted, func(a, b int) bool {
+		return sorted[a].Time.Before(sorted[b].Time)
+	})
+
+	periods := []period{}
+	for _, r := range sorted {
+		p := period{
+			Start:   r.Time.Add(-r.Duration),
+			End:     r.Time,
+			Work:    r.Type.IsWork(),
+			Driving: r.Type == DRIVING,
+			Moving:  r.InMovingVehicle,
+		}
+		if l := len(periods); l > 0 {
+			last := periods[l-1]
+			if p.Start.Before(last.End) {
+				p.Start = last.End
+			}
+			if p.Start.After(last.End) {
+				periods = append(periods, period{Start: last.End, End: p.Start})
+			}
+		}
+		if p.End.After(p.Start) {
+			periods = append(periods, p)
+		}
+	}
+	return periods
+}
+
+// tracker 按时间顺序累计各项工时并记录违规
+type tracker struct {
+	// 连续工作
+	continuous      time.Duration
+	continuousStart time.Time
+	// 工作日
+	daily       time.Duration
+	driving     time.Duration
+	dayStart    time.Time
+	longestRest time.Duration
+	// 累计工作
+	cumulative      time.Duration
+	cumulativeStart time.Time
+	// 当前连续休息, 双人驾驶时在行驶车辆中的休息只计入rest
+	rest            time.Duration
+	stationary      time.Duration
+	stationaryStart time.Time
+	lastWork        time.Time
+
+	schemes    Schemes
+	dayStarts  []time.Time
+	violations []Violation
+}
+
+func newTracker(ss Schemes) *tracker {
+	return &tracker{schemes: ss, violations: []Violation{}}
+}
+
+// scheme 获取at时刻适用的方案, 各项累计按其开始时适用的方案检查
+func (t *tracker) scheme(at time.Time) *Scheme {
+	return t.schemes.at(at)
+}
+
+func (t *tracker) dayEnd() time.Time {
+	return t.dayStart.Add(t.scheme(t.dayStart).WorkDay.duration())
+}
+
+func (t *tracker) inDay() bool {
+	return !t.dayStart.IsZero()
+}
+
+func (t *tracker) add(p period) {
+	if p.Work {
+		// 工作时段跨越工作日结束时拆分处理
+		if t.inDay() && p.Start.Before(t.dayEnd()) && p.End.After(t.dayEnd()) {
+			end := t.dayEnd()
+			t.work(period{Start: p.Start, End: end, Work: true, Driving: p.Driving})
+			t.work(period{Start: end, End: p.End, Work: true, Driving: p.Driving})
+			return
+		}
+		t.work(p)
+		return
+	}
+	t.relax(p)
+}
+
+func (t *tracker) work(p period) {
+	s := t.scheme(p.Start)
+	if t.rest >= s.ContinuousBreak.duration() {
+		t.closeContinuous()
+	}
+	if t.stationary >= s.CumulativeRest.duration() {
+		t.closeCumulative()
+	}
+	if t.stationary >= s.DailyRest.duration() || (t.inDay() && !p.Start.Before(t.dayEnd())) {
+		t.closeDay(p.Start)
+	}
+	if !t.inDay() {
+		t.dayStart = p.Start
+		t.dayStarts = append(t.dayStarts, p.Start)
+	}
+	if t.continuousStart.IsZero() {
+		t.continuousStart = p.Start
+	}
+	if t.cumulativeStart.IsZero() {
+		t.cumulativeStart = p.Start
+	}
+	t.rest = 0
+	t.stationary = 0
+	t.stationaryStart = time.Time{}
+
+	d := p.length()
+	t.continuous += d
+	t.daily += d
+	if p.Driving {
+		t.driving += d
+	}
+	t.cumulative += d
+	t.lastWork = p.End
+}
+
+// relax 休息时段, 行驶车辆中的休息可作为连续工作的中断, 但不计入工作日及累计工作所需的连续休息
+func (t *tracker) relax(p period) {
+	t.rest += p.length()
+	if p.Moving {
+		t.stationary = 0
+		t.stationaryStart = time.Time{}
+		return
+	}
+	if t.stationaryStart.IsZero() {
+		t.stationaryStart = p.Start
+	}
+	t.stationary += p.length()
+	if !t.inDay() {
+		return
+	}
+	// 工作日内最长连续休息
+	start, end := t.stationaryStart, p.End
+	if start.Before(t.dayStart) {
+		start = t.dayStart
+	}
+	if end.After(t.dayEnd()) {
+		end = t.dayEnd()
+	}
+	if d := end.Sub(start); d > t.longestRest {
+		t.longestRest = d
+	}
+}
+
+func (t *tracker) closeContinuous() {
+	s := t.scheme(t.continuousStart)
+	if limit := s.ContinuousWork.duration(); t.continuous > limit {
+		t.violations = append(t.violations, Violation{
+			Rule:    CONTINUOUSWORK,
+			Scheme:  s.Name,
+			Start:   t.continuousStart,
+			End:     t.lastWork,
+			Overrun: t.continuous - limit,
+		})
+	}
+	t.continuous = 0
+	t.continuousStart = time.Time{}
+}
+
+func (t *tracker) closeCumulative() {
+	s := t.scheme(t.cumulativeStart)
+	if limit := s.CumulativeWork.duration(); t.cumulative > limit {
+		t.violations = append(t.violations, Violation{
+			Rule:    CUMULATIVEWORK,
+			Scheme:  s.Name,
+			Start:   t.cumulativeStart,
+			End:     t.lastWork,
+			Overrun: t.cumulative - limit,
+		})
+	}
+	t.cumulative = 0
+	t.cumulativeStart = time.Time{}
+}
+
+// closeDay 结束当前工作日, now之前工作日的24小时已全部过去时才检查休息
+func (t *tracker) closeDay(now time.Time) {
+	if !t.inDay() {
+		return
+	}
+	s := t.scheme(t.dayStart)
+	if limit := s.DailyWork.duration(); t.daily > limit {
+		t.violations = append(t.violations, Violation{
+			Rule:    DAILYWORK,
+			Scheme:  s.Name,
+			Start:   t.dayStart,
+			End:     t.lastWork,
+			Overrun: t.daily - limit,
+		})
+	}
+	if minimum := s.DailyRest.duration(); !now.Before(t.dayEnd()) && t.longestRest < minimum {
+		t.violations = append(t.violations, Violation{
+			Rule:    DAILYREST,
+			Scheme:  s.Name,
+			Start:   t.dayStart,
+			End:     t.dayEnd(),
+			Overrun: minimum - t.longestRest,
+		})
+	}
+	t.daily = 0
+	t.driving = 0
+	t.longestRest = 0
+	t.dayStart = time.Time{}
+}
+
+// finish 结束计算, 检查尚未关闭的各项累计
+func (t *tracker) finish(now time.Time) []Violation {
+	t.closeContinuous()
+	t.closeCumulative()
+	t.closeDay(now)
+	sort.SliceStable(t.violations, func(a, b int) bool {
+		return t.violations[a].Start.Before(t.violations[b].Start)
+	})
+	return t.violations
+}
+
+// Violations 按记录发生时适用的方案检查记录并返回违规列表
+func (rs Records) Violations(ss Schemes) []Violation {
+	t := newTracker(ss)
+	periods := rs.timeline()
+	if len(periods) == 0 {
+		return []Violation{}
+	}
+	for _, p := range periods {
+		t.add(p)
+	}
+	return t.finish(periods[len(periods)-1].End)
+}
+
+// WorkDay 工作日, 从符合条件的休息后第一个工作时段开始, 长度由适用的方案决定,
+// 在此期间再次休息满规定时长后开始工作则提前结束
+type WorkDay struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// WorkDays 按工作日划分记录, 与合规计算使用相同的工作日定义
+func (rs Records) WorkDays(ss Schemes) []WorkDay {
+	t := newTracker(ss)
+	for _, p := range rs.timeline() {
+		t.add(p)
+	}
+	days := make([]WorkDay, len(t.dayStarts))
+	for i, start := range t.dayStarts {
+		days[i] = WorkDay{Start: start, End: start.Add(ss.at(start).WorkDay.duration())}
+		if i+1 < len(t.dayStarts) && t.dayStarts[i+1].Before(days[i].End) {
+			days[i].End = t.dayStarts[i+1]
+		}
+	}
+	return days
+}
+
+// Counter 单项工时累计状态
+type Counter struct {
+	Rule       Rule          `json:"rule"`
+	Worked     time.Duration `json:"worked"`
+	WorkLeft   time.Duration `json:"workLeft"`
+	RestNeeded time.Duration `json:"restNeeded"`
+}
+
+// Status 司机当前工时状态, Driving和OtherWork为当前工作日内的驾驶及其它工作时长, Scheme为当前适用的方案名称
+type Status struct {
+	Scheme    string        `json:"scheme"`
+	Working   bool          `json:"working"`
+	Since     time.Time     `json:"since"`
+	Driving   time.Duration `json:"driving"`
+	OtherWork time.Duration `json:"otherWork"`
+	Counters  []Counter     `json:"counters"`
+}
+
+func newCounter(rule Rule, worked time.Duration, limit, reset, rest time.Duration) Counter {
+	c := Counter{Rule: rule, WorkLeft: limit}
+	if rest >= reset || worked == 0 {
+		return c
+	}
+	c.Worked = worked
+	c.RestNeeded = reset - rest
+	if worked < limit {
+		c.WorkLeft = limit - worked
+	} else {
+		c.WorkLeft = 0
+	}
+	return c
+}
+
+// Status 根据历史记录计算now时刻的工时状态, 最后一条记录之后视为与其类型相反的进行中时段
+func (rs Records) Status(now time.Time, ss Schemes) *Status {
+	t := newTracker(ss)
+	periods := rs.timeline()
+	status := &Status{}
+	if l := len(periods); l > 0 {
+		last := periods[l-1]
+		status.Working = !last.Work
+		status.Since = last.End
+		if now.After(last.End) {
+			periods = append(periods, period{Start: last.End, End: now, Work: status.Working})
+		}
+	}
+	for _, p := range periods {
+		t.add(p)
+	}
+
+	daily, driving := t.daily, t.driving
+	if t.inDay() && !now.Before(t.dayEnd()) {
+		daily, driving = 0, 0
+	}
+	s := ss.at(now)
+	status.Scheme = s.Name
+	status.Driving, status.OtherWork = driving, daily-driving
+	status.Counters = []Counter{
+		newCounter(CONTINUOUSWORK, t.continuous, s.ContinuousWork.duration(), s.ContinuousBreak.duration(), t.rest),
+		newCounter(DAILYWORK, daily, s.DailyWork.duration(), s.DailyRest.duration(), t.stationary),
+		newCounter(CUMULATIVEWORK, t.cumulative, s.CumulativeWork.duration(), s.CumulativeRest.duration(), t.stationary),
+	}
+	return status
+}
+
+// cumulativeResetBefore 记录中是否有在at之前开始、满足累计工作重置时长的连续休息,
+// 行驶车辆中的休息不计入, 之前的工时不再影响at之后的累计
+func (rs Records) cumulativeResetBefore(at time.Time, ss Schemes) bool {
+	var start time.Time
+	for _, p := range rs.timeline() {
+		if p.Work || p.Moving {
+			if !p.Start.Before(at) {
+				return false
+			}
+			start = time.Time{}
+			continue
+		}
+		if start.IsZero() {
+			start = p.Start
+		}
+		if start.Before(at) && p.End.Sub(start) >= ss.at(start).CumulativeRest.duration() {
+			return true
+		}
+	}
+	return false
+}
+
+// GetComplianceRecords 获取计算from至to合规情况所需的记录, 按ComplianceLookback逐段向前追溯,
+// 直到from之前出现累计工作重置的休息或没有更早的记录, 以免累计工时从周期中间开始计算
+func GetComplianceRecords(driverID primitive.ObjectID, from, to time.Time, ss Schemes) (Records, error) {
+	for start := from.Add(-ComplianceLookback); ; start = start.Add(-ComplianceLookback) {
+		records, err := GetRecords(driverID, start, to, false)
+		if err != nil {
+			return nil, err
+		}
+		if Records(records).cumulativeResetBefore(from, ss) {
+			return records, nil
+		}
+		filter := bson.M{"driverID": driverID, "deletedAt": nil, "time": bson.M{"$lt": start}}
+		count, err := recordCollection.CountDocuments(context.TODO(), filter, options.Count().SetLimit(1))
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return records, nil
+		}
+	}
+}
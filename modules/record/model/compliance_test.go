@@ -0,0 +1,267 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2020, 3, 2, 6, 0, 0, 0, time.UTC)
+
+// span 一段指定类型和时长的记录
+type span struct {
+	typ      Type
+	duration time.Duration
+}
+
+func drive(d time.Duration) span { return span{DRIVING, d} }
+func rest(d time.Duration) span  { return span{REST, d} }
+
+// sequence 从start开始依次衔接的记录
+func sequence(start time.Time, spans ...span) Records {
+	rs := Records{}
+	for _, s := range spans {
+		start = start.Add(s.duration)
+		rs = append(rs, Record{Type: s.typ, Time: start, Duration: s.duration})
+	}
+	return rs
+}
+
+// workWeek 5个工作日, 每天工作13小时并休息10小时, 共65小时
+func workWeek() []span {
+	spans := []span{}
+	for i := 0; i < 5; i++ {
+		spans = append(spans, drive(330*time.Minute), rest(30*time.Minute), drive(330*time.Minute), rest(30*time.Minute), drive(2*time.Hour), rest(10*time.Hour))
+	}
+	return spans
+}
+
+func TestTimeline(t *testing.T) {
+	deletedAt := t0
+	rs := Records{
+		{Type: REST, Time: t0.Add(6 * time.Hour), Duration: 90 * time.Minute},
+		{Type: OTHERWORK, Time: t0.Add(2 * time.Hour), Duration: 2 * time.Hour},
+		{Type: DRIVING, Time: t0.Add(7 * time.Hour), Duration: time.Hour, DeletedAt: &deletedAt},
+		{Type: DRIVING, Time: t0.Add(5 * time.Hour), Duration: 2 * time.Hour},
+	}
+	want := []period{
+		{Start: t0, End: t0.Add(2 * time.Hour), Work: true},
+		{Start: t0.Add(2 * time.Hour), End: t0.Add(3 * time.Hour)},
+		{Start: t0.Add(3 * time.Hour), End: t0.Add(5 * time.Hour), Work: true, Driving: true},
+		{Start: t0.Add(5 * time.Hour), End: t0.Add(6 * time.Hour)},
+	}
+	got := rs.timeline()
+	if len(got) != len(want) {
+		t.Fatalf("timeline = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("period %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestViolations(t *testing.T) {
+	h, m := time.Hour, time.Minute
+	afms := Schemes{{From: t0, Scheme: &Scheme{
+		Name:            "afms",
+		ContinuousWork:  7,
+		ContinuousBreak: HR0D5,
+		WorkDay:         HR24,
+		DailyWork:       HR13,
+		DailyRest:       HR10,
+		CumulativeWork:  HR70,
+		CumulativeRest:  HR24,
+	}}}
+	type violation struct {
+		rule    Rule
+		scheme  string
+		overrun time.Duration
+	}
+	tests := []struct {
+		name  string
+		spans []span
+		ss    Schemes
+		want  []violation
+	}{
+		{"continuous at limit", []span{drive(330 * m), rest(30 * m)}, nil, nil},
+		{"continuous over limit", []span{drive(331 * m)}, nil, []violation{{CONTINUOUSWORK, "default", m}}},
+		{"break too short", []span{drive(3 * h), rest(29 * m), drive(3 * h)}, nil, []violation{{CONTINUOUSWORK, "default", 30 * m}}},
+		{"break long enough", []span{drive(3 * h), rest(30 * m), drive(3 * h)}, nil, nil},
+		{"continuous under alternative scheme", []span{drive(6 * h)}, afms, nil},
+		{"daily work at limit", []span{drive(5 * h), rest(30 * m), drive(5 * h), rest(30 * m), drive(3 * h)}, nil, nil},
+		{"daily work over limit", []span{drive(5 * h), rest(30 * m), drive(5 * h), rest(30 * m), drive(3*h + m)}, nil, []violation{{DAILYWORK, "default", m}}},
+		{"daily rest too short", []span{drive(5 * h), rest(30 * m), drive(5 * h), rest(9 * h), drive(h), rest(10 * h)}, nil, []violation{{DAILYREST, "default", h}}},
+		{"daily rest long enough", []span{drive(5 * h), rest(30 * m), drive(5 * h), rest(10 * h), drive(h), rest(10 * h)}, nil, nil},
+		{"daily rest not due before day ends", []span{drive(5 * h), rest(30 * m), drive(5 * h)}, nil, nil},
+		{"cumulative at limit", append(workWeek(), drive(5*h)), nil, nil},
+		{"cumulative over limit", append(workWeek(), drive(5*h+m)), nil, []violation{{CUMULATIVEWORK, "default", m}}},
+		{"cumulative reset after 24 hours", append(workWeek(), drive(5*h), rest(24*h), drive(h)), nil, nil},
+		{"cumulative not reset before 24 hours", append(workWeek(), drive(5*h), rest(24*h-m), drive(h)), nil, []violation{{CUMULATIVEWORK, "default", h}}},
+	}
+	for _, tt := range tests {
+		got := sequence(t0, tt.spans...).Violations(tt.ss)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: violations = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i, w := range tt.want {
+			if got[i].Rule != w.rule || got[i].Scheme != w.scheme || got[i].Overrun != w.overrun {
+				t.Errorf("%s: violation %d = %v, want %v", tt.name, i, got[i], w)
+			}
+		}
+	}
+}
+
+func TestTrackerMovingRest(t *testing.T) {
+	tr := newTracker(nil)
+	tr.add(period{Start: t0, End: t0.Add(5 * time.Hour), Work: true, Driving: true})
+	tr.add(period{Start: t0.Add(5 * time.Hour), End: t0.Add(5*time.Hour + 30*time.Minute), Moving: true})
+	tr.add(period{Start: t0.Add(5*time.Hour + 30*time.Minute), End: t0.Add(6*time.Hour + 30*time.Minute), Work: true})
+	// 行驶车辆中的休息中断连续工作, 但不计入工作日休息
+	if tr.continuous != time.Hour || tr.stationary != 0 || tr.cumulative != 6*time.Hour {
+		t.Errorf("continuous, stationary, cumulative = %s, %s, %s", tr.continuous, tr.stationary, tr.cumulative)
+	}
+	tr.add(period{Start: t0.Add(6*time.Hour + 30*time.Minute), End: t0.Add(16*time.Hour + 30*time.Minute), Moving: true})
+	tr.add(period{Start: t0.Add(16*time.Hour + 30*time.Minute), End: t0.Add(17*time.Hour + 30*time.Minute), Work: true})
+	if len(tr.dayStarts) != 1 || tr.daily != 7*time.Hour {
+		t.Errorf("dayStarts, daily = %v, %s; want one day of 7h", tr.dayStarts, tr.daily)
+	}
+}
+
+func TestTrackerClose(t *testing.T) {
+	tr := newTracker(nil)
+	tr.add(period{Start: t0, End: t0.Add(2 * time.Hour), Work: true})
+	tr.closeDay(t0.Add(3 * time.Hour))
+	if len(tr.violations) != 0 || tr.inDay() || tr.daily != 0 {
+		t.Errorf("closeDay before day end: violations %v, inDay %v, daily %s", tr.violations, tr.inDay(), tr.daily)
+	}
+
+	tr.add(period{Start: t0.Add(4 * time.Hour), End: t0.Add(6 * time.Hour), Work: true})
+	tr.add(period{Start: t0.Add(6 * time.Hour), End: t0.Add(14 * time.Hour)})
+	tr.closeDay(t0.Add(28 * time.Hour))
+	if len(tr.violations) != 1 || tr.violations[0].Rule != DAILYREST || tr.violations[0].Overrun != 2*time.Hour {
+		t.Errorf("closeDay after day end: violations %v", tr.violations)
+	}
+
+	tr.closeContinuous()
+	tr.closeCumulative()
+	if tr.continuous != 0 || tr.cumulative != 0 || !tr.continuousStart.IsZero() || !tr.cumulativeStart.IsZero() {
+		t.Errorf("counters not reset: %s, %s", tr.continuous, tr.cumulative)
+	}
+	if len(tr.violations) != 1 {
+		t.Errorf("violations = %v, want only daily rest", tr.violations)
+	}
+}
+
+func TestWorkDays(t *testing.T) {
+	h := time.Hour
+	tests := []struct {
+		name  string
+		spans []span
+		want  []WorkDay
+	}{
+		{"rest resets work day", []span{drive(2 * h), rest(10 * h), drive(2 * h)}, []WorkDay{
+			{Start: t0, End: t0.Add(12 * h)},
+			{Start: t0.Add(12 * h), End: t0.Add(36 * h)},
+		}},
+		{"short rest stays in work day", []span{drive(2 * h), rest(9 * h), drive(2 * h)}, []WorkDay{
+			{Start: t0, End: t0.Add(24 * h)},
+		}},
+		{"work across day end is split", []span{drive(2 * h), rest(9 * h), drive(2 * h), rest(9 * h), drive(4 * h)}, []WorkDay{
+			{Start: t0, End: t0.Add(24 * h)},
+			{Start: t0.Add(24 * h), End: t0.Add(48 * h)},
+		}},
+	}
+	for _, tt := range tests {
+		got := sequence(t0, tt.spans...).WorkDays(nil)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: WorkDays = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if !got[i].Start.Equal(tt.want[i].Start) || !got[i].End.Equal(tt.want[i].End) {
+				t.Errorf("%s: day %d = %v, want %v", tt.name, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	h, m := time.Hour, time.Minute
+	rs := sequence(t0, drive(5*h))
+	end := t0.Add(5 * h)
+	tests := []struct {
+		name string
+		now  time.Time
+		want []Counter
+	}{
+		{"just stopped", end, []Counter{
+			{Rule: CONTINUOUSWORK, Worked: 5 * h, WorkLeft: 30 * m, RestNeeded: 30 * m},
+			{Rule: DAILYWORK, Worked: 5 * h, WorkLeft: 8 * h, RestNeeded: 10 * h},
+			{Rule: CUMULATIVEWORK, Worked: 5 * h, WorkLeft: 65 * h, RestNeeded: 24 * h},
+		}},
+		{"short break", end.Add(10 * m), []Counter{
+			{Rule: CONTINUOUSWORK, Worked: 5 * h, WorkLeft: 30 * m, RestNeeded: 20 * m},
+			{Rule: DAILYWORK, Worked: 5 * h, WorkLeft: 8 * h, RestNeeded: 590 * m},
+			{Rule: CUMULATIVEWORK, Worked: 5 * h, WorkLeft: 65 * h, RestNeeded: 1430 * m},
+		}},
+		{"break taken", end.Add(30 * m), []Counter{
+			{Rule: CONTINUOUSWORK, WorkLeft: 330 * m},
+			{Rule: DAILYWORK, Worked: 5 * h, WorkLeft: 8 * h, RestNeeded: 570 * m},
+			{Rule: CUMULATIVEWORK, Worked: 5 * h, WorkLeft: 65 * h, RestNeeded: 1410 * m},
+		}},
+		{"daily rest taken", end.Add(10 * h), []Counter{
+			{Rule: CONTINUOUSWORK, WorkLeft: 330 * m},
+			{Rule: DAILYWORK, WorkLeft: 13 * h},
+			{Rule: CUMULATIVEWORK, Worked: 5 * h, WorkLeft: 65 * h, RestNeeded: 14 * h},
+		}},
+		{"cumulative rest taken", end.Add(24 * h), []Counter{
+			{Rule: CONTINUOUSWORK, WorkLeft: 330 * m},
+			{Rule: DAILYWORK, WorkLeft: 13 * h},
+			{Rule: CUMULATIVEWORK, WorkLeft: 70 * h},
+		}},
+	}
+	for _, tt := range tests {
+		s := rs.Status(tt.now, nil)
+		if s.Working || !s.Since.Equal(end) || s.Scheme != DefaultScheme.Name {
+			t.Errorf("%s: working %v since %v scheme %q", tt.name, s.Working, s.Since, s.Scheme)
+		}
+		for i, c := range tt.want {
+			if s.Counters[i] != c {
+				t.Errorf("%s: counter %d = %+v, want %+v", tt.name, i, s.Counters[i], c)
+			}
+		}
+	}
+
+	if s := rs.Status(end.Add(time.Hour), nil); s.Driving != 5*h || s.OtherWork != 0 {
+		t.Errorf("driving, other work = %s, %s", s.Driving, s.OtherWork)
+	}
+	if s := (Records{}).Status(end, nil); s.Working || len(s.Counters) != 3 || s.Counters[0].WorkLeft != 330*m {
+		t.Errorf("status without records = %+v", s)
+	}
+}
+
+func TestCumulativeResetBefore(t *testing.T) {
+	h := time.Hour
+	tests := []struct {
+		name  string
+		rs    Records
+		at    time.Time
+		reset bool
+	}{
+		{"full rest", sequence(t0, drive(5*h), rest(24*h), drive(h)), t0.Add(30 * h), true},
+		{"rest too short", sequence(t0, drive(5*h), rest(23*h), drive(h)), t0.Add(29 * h), false},
+		{"rest and off duty together", sequence(t0, drive(5*h), rest(12*h), span{OFFDUTY, 12 * h}, drive(h)), t0.Add(30 * h), true},
+		{"rest spanning at", sequence(t0, drive(5*h), rest(24*h), drive(h)), t0.Add(6 * h), true},
+		{"rest after at", sequence(t0, drive(5*h), rest(24*h), drive(h)), t0.Add(h), false},
+		{"rest in moving vehicle", Records{
+			{Type: DRIVING, Time: t0.Add(5 * h), Duration: 5 * h},
+			{Type: REST, Time: t0.Add(29 * h), Duration: 24 * h, InMovingVehicle: true},
+		}, t0.Add(30 * h), false},
+	}
+	for _, tt := range tests {
+		if got := tt.rs.cumulativeResetBefore(tt.at, nil); got != tt.reset {
+			t.Errorf("%s: cumulativeResetBefore = %v, want %v", tt.name, got, tt.reset)
+		}
+	}
+}
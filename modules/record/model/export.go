@@ -0,0 +1,69 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExportBatchSize 导出时每批读取的记录数
+const ExportBatchSize = 500
+
+// ExportRecords 通过游标按批读取司机时间段内的记录及其笔记, 每批调用一次fn
+func ExportRecords(driverIDs []primitive.ObjectID, from, to time.Time, getDeleted bool, fn func(Records, map[primitive.ObjectID]DifNotes) error) error {
+	filter := bson.M{
+		"driverID": bson.M{"$in": driverIDs},
+		"time":     bson.M{"$gte": from, "$lte": to},
+	}
+	if !getDeleted {
+		filter["deletedAt"] = nil
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "driverID", Value: 1}, {Key: "time", Value: 1}}).
+		SetBatchSize(ExportBatchSize)
+	cursor, err := recordCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.TODO())
+
+	batch := make(Records, 0, ExportBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		recordIDs := make([]primitive.ObjectID, len(batch))
+		for i, v := range batch {
+			recordIDs[i] = v.ID
+		}
+		notesMap, err := GetNotesByRecordIDs(recordIDs)
+		if err != nil {
+			return err
+		}
+		if err = fn(batch, notesMap); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for cursor.Next(context.TODO()) {
+		r := Record{}
+		if err = cursor.Decode(&r); err != nil {
+			return err
+		}
+		batch = append(batch, r)
+		if len(batch) == ExportBatchSize {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	locModel "github.com/chadhao/logit/modules/location/model"
+)
+
+const (
+	// defaultGPSTolerance 默认允许申报里程与GPS里程相差的百分比
+	defaultGPSTolerance = 10.0
+	// gpsCoverage 行驶位置距记录开始或结束超过该时长时视为轨迹不完整, 不计算GPS里程
+	gpsCoverage = 10 * time.Minute
+	// gpsRetryWindow 审计时只为该时长内的记录补充计算GPS里程, 离线缓存的位置通常在此期间上传,
+	// 更早的记录轨迹不完整时不再重复查询
+	gpsRetryWindow = 7 * 24 * time.Hour
+)
+
+// gpsTolerance 读取允许的误差百分比, 未配置时使用默认值
+func gpsTolerance() float64 {
+	tolerance, err := strconv.ParseFloat(config["record.gps.tolerance"], 64)
+	if err != nil || tolerance < 0 {
+		return defaultGPSTolerance
+	}
+	return tolerance
+}
+
+// gpsDistance 根据记录期间司机的行驶位置计算GPS里程(公里), 轨迹不完整或已被压缩时返回nil
+func (r *Record) gpsDistance() (*float64, error) {
+	start := r.Time.Add(-r.Duration)
+	drivingLocs, err := locModel.GetDrivingLocs(r.DriverID, start, r.Time)
+	if err != nil {
+		return nil, err
+	}
+	n := len(drivingLocs)
+	if n < 2 || drivingLocs[0].CreatedAt.Sub(start) > gpsCoverage || r.Time.Sub(drivingLocs[n-1].CreatedAt) > gpsCoverage {
+		return nil, nil
+	}
+	for _, v := range drivingLocs {
+		if v.Compacted {
+			return nil, nil
+		}
+	}
+	d := locModel.TrackDistance(drivingLocs) / 1000
+	return &d, nil
+}
+
+// gpsMismatch 比较申报里程与GPS里程, 相差超过容差时返回说明, 容差不小于OdometerTolerance
+func (r *Record) gpsMismatch() string {
+	if r.GPSDistance == nil || r.StartMileAge == nil || r.EndMileAge == nil {
+		return ""
+	}
+	declared := *r.EndMileAge - *r.StartMileAge
+	tolerance := math.Max(*r.GPSDistance*gpsTolerance()/100, OdometerTolerance)
+	if math.Abs(declared-*r.GPSDistance) <= tolerance {
+		return ""
+	}
+	return fmt.Sprintf("declared distance %.1f km differs from GPS distance %.1f km by %.1f km",
+		declared, *r.GPSDistance, declared-*r.GPSDistance)
+}
+
+// trackGPSDistance 计算并保存驾驶记录的GPS里程, 返回与申报里程不符的说明
+func (r *Record) trackGPSDistance() (string, error) {
+	if r.Type != DRIVING {
+		return "", nil
+	}
+	d, err := r.gpsDistance()
+	if err != nil || d == nil {
+		return "", err
+	}
+	// gpsDistance不参与哈希计算, 可在记录写入后补充
+	update := bson.M{"$set": bson.M{"gpsDistance": *d}}
+	if _, err = recordCollection.UpdateOne(context.TODO(), bson.M{"_id": r.ID}, update); err != nil {
+		return "", err
+	}
+	r.GPSDistance = d
+	return r.gpsMismatch(), nil
+}
+
+// checkGPSDistance 记录添加后计算GPS里程, 与申报里程不符时添加系统笔记
+func (r *Record) checkGPSDistance() error {
+	detail, err := r.trackGPSDistance()
+	if err != nil || detail == "" {
+		return err
+	}
+	return r.addSystemNote(detail)
+}
@@ -0,0 +1,294 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	valid "github.com/asaskevich/govalidator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RecordVersion 记录修改前的历史版本
+type RecordVersion struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id" valid:"-"`
+	RecordID  primitive.ObjectID `bson:"recordID" json:"recordID" valid:"required"`
+	Version   int                `bson:"version" json:"version" valid:"-"`
+	Record    Record             `bson:"record" json:"record" valid:"-"`
+	NoteID    primitive.ObjectID `bson:"noteID" json:"noteID" valid:"required"`
+	By        primitive.ObjectID `bson:"by" json:"by" valid:"required"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt" valid:"required"`
+	// 修改完成前保留修改笔记内容, 用于中途失败后恢复
+	Comment string `bson:"comment,omitempty" json:"-" valid:"-"`
+	Pending bool   `bson:"pending,omitempty" json:"-" valid:"-"`
+}
+
+// amendTimeout 未完成的修改超过该时长后视为已中断, 可由下一次修改恢复
+const amendTimeout = time.Minute
+
+// Add 历史版本添加到数据库
+func (rv *RecordVersion) Add() error {
+	if _, err := valid.ValidateStruct(rv); err != nil {
+		return err
+	}
+	if _, err := historyCollection.InsertOne(context.TODO(), rv); err != nil {
+		return err
+	}
+	return nil
+}
+
+// changedFields 返回修改后的记录与原记录不同的字段
+func (r *Record) changedFields(amended *Record) []string {
+	fields := []string{}
+	if r.Type != amended.Type {
+		fields = append(fields, "type")
+	}
+	if !r.Time.Equal(amended.Time) {
+		fields = append(fields, "time")
+	}
+	if r.Duration != amended.Duration {
+		fields = append(fields, "duration")
+	}
+	if r.StartLocation != amended.StartLocation {
+		fields = append(fields, "startLocation")
+	}
+	if r.EndLocation != amended.EndLocation {
+		fields = append(fields, "endLocation")
+	}
+	if !equalMileAge(r.StartMileAge, amended.StartMileAge) {
+		fields = append(fields, "startDistance")
+	}
+	if !equalMileAge(r.EndMileAge, amended.EndMileAge) {
+		fields = append(fields, "endDistance")
+	}
+	return fields
+}
+
+func equalMileAge(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// neighbours 获取记录前后相邻的未删除记录, 不存在时返回空记录
+func (r *Record) neighbours() (prev, next *Record, err error) {
+	prev, next = new(Record), new(Record)
+	filter := bson.M{"driverID": r.DriverID, "deletedAt": nil, "_id": bson.M{"$ne": r.ID}}
+
+	filter["time"] = bson.M{"$lt": r.Time}
+	opts := options.FindOne().SetSort(bson.D{{Key: "time", Value: -1}})
+	if err = recordCollection.FindOne(context.TODO(), filter, opts).Decode(prev); err != nil && err != mongo.ErrNoDocuments {
+		return
+	}
+
+	filter["time"] = bson.M{"$gt": r.Time}
+	opts = options.FindOne().SetSort(bson.D{{Key: "time", Value: 1}})
+	if err = recordCollection.FindOne(context.TODO(), filter, opts).Decode(next); err != nil && err != mongo.ErrNoDocuments {
+		return
+	}
+	return prev, next, nil
+}
+
+// Amend 修改记录, 原记录保存为历史版本并自动生成修改笔记.
+// 各步骤按可恢复的顺序执行, 中途失败时, 下一次修改会先完成或撤销上次的修改
+func (r *Record) Amend(amended *Record, by primitive.ObjectID, reason string) (*ModificationNote, error) {
+	resumed, err := r.resumeAmend()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case r.DeletedAt != nil:
+		return nil, errors.New("record has already been deleted")
+	case amended.ID != r.ID || amended.DriverID != r.DriverID:
+		return nil, errors.New("record identity cannot be amended")
+	case amended.Type.IsWork() != r.Type.IsWork():
+		return nil, errors.New("work and rest cannot be swapped")
+	case reason == "":
+		return nil, errors.New("reason is required")
+	}
+	fields := r.changedFields(amended)
+	if len(fields) == 0 {
+		// 重试已由恢复完成的修改
+		if resumed != nil {
+			return resumed, nil
+		}
+		return nil, errors.New("nothing to amend")
+	}
+
+	// 与前后记录的衔接检查
+	prev, next, err := r.neighbours()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkAmendTimes(amended, prev, next); err != nil {
+		return nil, err
+	}
+	if err := amended.StartLocation.fillFull(); err != nil {
+		return nil, err
+	}
+	if err := amended.beforeAdd(prev); err != nil {
+		return nil, err
+	}
+	if (Record{}) != *next {
+		if err := next.checkContinuity(amended); err != nil {
+			return nil, err
+		}
+	}
+
+	// 时间改变后GPS里程在替换后重新计算
+	if !amended.Time.Equal(r.Time) || amended.Duration != r.Duration {
+		amended.GPSDistance = nil
+	}
+	return r.replaceVersion(amended, by, fmt.Sprintf("%s (amended: %s)", reason, strings.Join(fields, ", ")))
+}
+
+// checkAmendTimes 记录的开始和结束时间分别与前后记录衔接, 修改不会同时移动相邻记录,
+// 因此有上一条记录时不能修改开始时间, 有下一条记录时不能修改结束时间, 需要先删除之后的记录再修改
+func (r *Record) checkAmendTimes(amended, prev, next *Record) error {
+	if (Record{}) != *prev && !amended.Time.Add(-amended.Duration).Equal(r.Time.Add(-r.Duration)) {
+		return errors.New("start time cannot be amended as the record follows another record")
+	}
+	if (Record{}) != *next && !amended.Time.Equal(r.Time) {
+		return errors.New("end time cannot be amended as another record follows")
+	}
+	return nil
+}
+
+// replaceVersion 将记录替换为新版本: 先保存未完成的历史版本, 再按版本号替换记录, 最后完成链接、笔记和汇总
+func (r *Record) replaceVersion(amended *Record, by primitive.ObjectID, comment string) (*ModificationNote, error) {
+	rv := &RecordVersion{
+		ID:        primitive.NewObjectID(),
+		RecordID:  r.ID,
+		Version:   r.Version,
+		Record:    *r,
+		NoteID:    primitive.NewObjectID(),
+		By:        by,
+		CreatedAt: time.Now(),
+		Comment:   comment,
+		Pending:   true,
+	}
+	if err := rv.Add(); err != nil {
+		return nil, err
+	}
+
+	// 数据库替换为新版本, 记录已被其他请求修改时撤销历史版本
+	amended.Version = r.Version + 1
+	amended.ChainLink = ChainLink{}
+	filter := bson.M{"_id": r.ID, "version": r.Version}
+	if r.Version == 0 {
+		// 早于版本号的记录没有version字段
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	res, err := recordCollection.ReplaceOne(context.TODO(), filter, amended)
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 {
+		if _, err := historyCollection.DeleteOne(context.TODO(), bson.M{"_id": rv.ID}); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("record has been amended by others")
+	}
+	return amended.completeAmend(rv)
+}
+
+// resumeAmend 处理上次中断的修改并重新读取记录: 记录尚未替换时删除遗留的历史版本, 已替换时补完剩余步骤
+func (r *Record) resumeAmend() (*ModificationNote, error) {
+	rv := new(RecordVersion)
+	err := historyCollection.FindOne(context.TODO(), bson.M{"recordID": r.ID, "pending": true}).Decode(rv)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(rv.CreatedAt) < amendTimeout {
+		return nil, errors.New("record is being amended")
+	}
+	current, err := GetRecord(r.ID)
+	if err != nil {
+		return nil, err
+	}
+	*r = *current
+	if current.Version == rv.Version {
+		_, err = historyCollection.DeleteOne(context.TODO(), bson.M{"_id": rv.ID})
+		return nil, err
+	}
+	mn, err := current.completeAmend(rv)
+	if err != nil {
+		return nil, err
+	}
+	*r = *current
+	return mn, nil
+}
+
+// completeAmend 记录替换后链接到哈希链, 添加修改笔记并更新汇总, 已完成的步骤跳过, 全部完成后清除未完成标记
+func (r *Record) completeAmend(rv *RecordVersion) (*ModificationNote, error) {
+	// 修改后的版本作为新节点链接到哈希链, 原版本保留在历史中
+	if r.Seq == 0 {
+		if err := r.chain(); err != nil {
+			return nil, err
+		}
+	}
+	mn := new(ModificationNote)
+	err := noteCollection.FindOne(context.TODO(), bson.M{"_id": rv.NoteID}).Decode(mn)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		mn = &ModificationNote{
+			Note: Note{
+				ID:        rv.NoteID,
+				RecordID:  r.ID,
+				Type:      MODIFICATIONNOTE,
+				Comment:   rv.Comment,
+				CreatedAt: rv.CreatedAt,
+			},
+			By: rv.By,
+		}
+		if err := mn.Add(); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case mn.Seq == 0:
+		if err := mn.chain(); err != nil {
+			return nil, err
+		}
+	}
+	// 与添加记录相同, 重新检查里程连续性和GPS里程
+	if r.DeletedAt == nil {
+		r.afterAdd()
+	}
+	// 记录已替换, 汇总更新失败时只记录日志, 以免客户端收到已完成修改或删除的失败结果
+	if err := rv.Record.updateSummaries(); err != nil {
+		log.Println("record summaries:", r.ID.Hex(), err)
+	}
+	if err := r.updateSummaries(); err != nil {
+		log.Println("record summaries:", r.ID.Hex(), err)
+	}
+	update := bson.M{"$unset": bson.M{"pending": ""}}
+	if _, err := historyCollection.UpdateOne(context.TODO(), bson.M{"_id": rv.ID}, update); err != nil {
+		return nil, err
+	}
+	return mn, nil
+}
+
+// GetRecordVersions 获取记录的全部历史版本
+func GetRecordVersions(recordID primitive.ObjectID) ([]RecordVersion, error) {
+	versions := []RecordVersion{}
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: 1}})
+	cursor, err := historyCollection.Find(context.TODO(), bson.M{"recordID": recordID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(context.TODO(), &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
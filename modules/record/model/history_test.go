@@ -0,0 +1,58 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckAmendTimes(t *testing.T) {
+	base := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	r := &Record{Type: DRIVING, Time: base, Duration: 2 * time.Hour}
+	prev := &Record{Type: REST, Time: base.Add(-2 * time.Hour), Duration: time.Hour}
+	next := &Record{Type: REST, Time: base.Add(time.Hour), Duration: time.Hour}
+	none := &Record{}
+
+	tests := []struct {
+		name       string
+		time       time.Time
+		duration   time.Duration
+		prev, next *Record
+		ok         bool
+	}{
+		{"unchanged in the middle", base, 2 * time.Hour, prev, next, true},
+		{"end moved in the middle", base.Add(-time.Minute), 2*time.Hour - time.Minute, prev, next, false},
+		{"start moved in the middle", base, time.Hour, prev, next, false},
+		{"end moved on latest", base.Add(30 * time.Minute), 150 * time.Minute, prev, none, true},
+		{"start moved on latest", base, time.Hour, prev, none, false},
+		{"start moved on first", base, time.Hour, none, next, true},
+		{"end moved on first", base.Add(time.Minute), 2 * time.Hour, none, next, false},
+		{"both moved on only record", base.Add(time.Hour), time.Hour, none, none, true},
+	}
+	for _, tt := range tests {
+		amended := *r
+		amended.Time, amended.Duration = tt.time, tt.duration
+		if err := r.checkAmendTimes(&amended, tt.prev, tt.next); (err == nil) != tt.ok {
+			t.Errorf("%s: checkAmendTimes = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	start, end := 100.0, 150.0
+	r := &Record{Type: DRIVING, Time: time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC), Duration: time.Hour, StartMileAge: &start}
+
+	amended := *r
+	if fields := r.changedFields(&amended); len(fields) != 0 {
+		t.Errorf("changedFields of copy = %v", fields)
+	}
+	other := start
+	amended.StartMileAge = &other
+	if fields := r.changedFields(&amended); len(fields) != 0 {
+		t.Errorf("changedFields of equal mileage = %v", fields)
+	}
+	amended.Type, amended.EndMileAge = OTHERWORK, &end
+	fields := r.changedFields(&amended)
+	if len(fields) != 2 || fields[0] != "type" || fields[1] != "endDistance" {
+		t.Errorf("changedFields = %v, want [type endDistance]", fields)
+	}
+}
@@ -0,0 +1,150 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	valid "github.com/asaskevich/govalidator"
+	"github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Inspection 司机为执法人员生成的限时只读检查授权
+type Inspection struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id" valid:"-"`
+	DriverID  primitive.ObjectID `bson:"driverID" json:"driverID" valid:"required"`
+	From      time.Time          `bson:"from" json:"from" valid:"required"`
+	To        time.Time          `bson:"to" json:"to" valid:"required"`
+	ExpiresAt time.Time          `bson:"expiresAt" json:"expiresAt" valid:"required"`
+	RevokedAt *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty" valid:"-"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt" valid:"required"`
+}
+
+// InspectionAccess 检查授权的访问记录
+type InspectionAccess struct {
+	ID           primitive.ObjectID `bson:"_id" json:"id" valid:"-"`
+	InspectionID primitive.ObjectID `bson:"inspectionID" json:"inspectionID" valid:"required"`
+	DriverID     primitive.ObjectID `bson:"driverID" json:"driverID" valid:"required"`
+	IP           string             `bson:"ip" json:"ip" valid:"-"`
+	UserAgent    string             `bson:"userAgent" json:"userAgent" valid:"-"`
+	AccessedAt   time.Time          `bson:"accessedAt" json:"accessedAt" valid:"required"`
+}
+
+// Add 检查授权添加到数据库
+func (i *Inspection) Add() error {
+	if _, err := valid.ValidateStruct(i); err != nil {
+		return err
+	}
+	if _, err := inspectionCollection.InsertOne(context.TODO(), i); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Revoke 提前撤销检查授权
+func (i *Inspection) Revoke() error {
+	if i.RevokedAt != nil {
+		return errors.New("inspection has already been revoked")
+	}
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"revokedAt": now}}
+	if _, err := inspectionCollection.UpdateOne(context.TODO(), bson.M{"_id": i.ID}, update); err != nil {
+		return err
+	}
+	i.RevokedAt = &now
+	return nil
+}
+
+// Token 签发检查授权令牌
+func (i *Inspection) Token() (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		Id:        i.ID.Hex(),
+		Subject:   i.DriverID.Hex(),
+		Issuer:    "logit.co.nz",
+		ExpiresAt: i.ExpiresAt.Unix(),
+	})
+	key, err := inspectionKey()
+	if err != nil {
+		return "", err
+	}
+	return token.SignedString(key)
+}
+
+// Log 记录一次访问
+func (i *Inspection) Log(ip, userAgent string) error {
+	ia := &InspectionAccess{
+		ID:           primitive.NewObjectID(),
+		InspectionID: i.ID,
+		DriverID:     i.DriverID,
+		IP:           ip,
+		UserAgent:    userAgent,
+		AccessedAt:   time.Now(),
+	}
+	if _, err := valid.ValidateStruct(ia); err != nil {
+		return err
+	}
+	_, err := inspectionAccessCollection.InsertOne(context.TODO(), ia)
+	return err
+}
+
+// inspectionKey 令牌签名密钥, 未配置时拒绝签发和验证, 以免使用空密钥
+func inspectionKey() ([]byte, error) {
+	key := config["record.inspection.key"]
+	if key == "" {
+		return nil, errors.New("inspection key not configured")
+	}
+	return []byte(key), nil
+}
+
+// GetInspection 通过id获取检查授权
+func GetInspection(id primitive.ObjectID) (*Inspection, error) {
+	i := new(Inspection)
+	err := inspectionCollection.FindOne(context.TODO(), bson.M{"_id": id}).Decode(i)
+	return i, err
+}
+
+// GetInspectionByToken 验证令牌并返回仍然有效的检查授权
+func GetInspectionByToken(tokenString string) (*Inspection, error) {
+	claims := &jwt.StandardClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("unexpected signing method")
+		}
+		return inspectionKey()
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid or expired inspection token")
+	}
+	id, err := primitive.ObjectIDFromHex(claims.Id)
+	if err != nil {
+		return nil, err
+	}
+	i, err := GetInspection(id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case i.DriverID.Hex() != claims.Subject:
+		return nil, errors.New("invalid inspection token")
+	case i.RevokedAt != nil:
+		return nil, errors.New("inspection has been revoked")
+	case i.ExpiresAt.Before(time.Now()):
+		return nil, errors.New("inspection has expired")
+	}
+	return i, nil
+}
+
+// GetInspectionAccesses 获取检查授权的访问记录
+func GetInspectionAccesses(inspectionID primitive.ObjectID) ([]InspectionAccess, error) {
+	accesses := []InspectionAccess{}
+	cursor, err := inspectionAccessCollection.Find(context.TODO(), bson.M{"inspectionID": inspectionID})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(context.TODO(), &accesses); err != nil {
+		return nil, err
+	}
+	return accesses, nil
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Migration 已完成的数据迁移标记
+type Migration struct {
+	Name        string    `bson:"_id" json:"name"`
+	CompletedAt time.Time `bson:"completedAt" json:"completedAt"`
+}
+
+// runMigration 执行尚未完成的迁移, 完成后写入标记, 之后启动时不再执行
+func runMigration(name string, migrate func() error) error {
+	err := migrationCollection.FindOne(context.TODO(), bson.M{"_id": name}).Err()
+	if err != mongo.ErrNoDocuments {
+		return err
+	}
+	if err = migrate(); err != nil {
+		return err
+	}
+	_, err = migrationCollection.InsertOne(context.TODO(), &Migration{Name: name, CompletedAt: time.Now()})
+	return err
+}
+
+// migrateWorkRecords 将旧版work类型记录迁移为新的记录类型,
+// 附有其它工作笔记的记录迁移为other_work, 其余迁移为driving.
+// 只迁移未链接到哈希链的记录, 之后从归档恢复的旧记录保持原类型, 以免改变其哈希
+func migrateWorkRecords() error {
+	ids, err := noteCollection.Distinct(context.TODO(), "recordID", bson.M{"noteType": OTHERWORKNOTE})
+	if err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		filter := bson.M{"type": WORK, "seq": nil, "_id": bson.M{"$in": ids}}
+		if _, err = recordCollection.UpdateMany(context.TODO(), filter, bson.M{"$set": bson.M{"type": OTHERWORK}}); err != nil {
+			return err
+		}
+	}
+	_, err = recordCollection.UpdateMany(context.TODO(), bson.M{"type": WORK, "seq": nil}, bson.M{"$set": bson.M{"type": DRIVING}})
+	return err
+}
+
+// migrateDeletedRecords 删除标记参与哈希计算之前已删除并已链接的记录, 按删除操作保存历史版本并重新链接.
+// 只处理去掉删除标记后哈希一致的记录, 其它哈希不一致的记录保持原样, 由验证报告
+func migrateDeletedRecords() error {
+	docs, err := findRaw(recordCollection, bson.M{"deletedAt": bson.M{"$ne": nil}, "seq": bson.M{"$gt": 0}})
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		e, err := newChainEntry(string(archiveRecord), doc)
+		if err != nil {
+			return err
+		}
+		if chainHash(e.Seq, e.PrevHash, e.content) == e.Hash {
+			continue
+		}
+		d := bson.D{}
+		elems, err := doc.Elements()
+		if err != nil {
+			return err
+		}
+		for _, v := range elems {
+			if v.Key() != "deletedAt" {
+				d = append(d, bson.E{Key: v.Key(), Value: v.Value()})
+			}
+		}
+		undeleted, err := bson.Marshal(d)
+		if err != nil {
+			return err
+		}
+		if content, err := chainContent(undeleted); err != nil {
+			return err
+		} else if chainHash(e.Seq, e.PrevHash, content) != e.Hash {
+			continue
+		}
+		r := new(Record)
+		if err = bson.Unmarshal(doc, r); err != nil {
+			return err
+		}
+		deleted := *r
+		r.DeletedAt = nil
+		if _, err = r.replaceVersion(&deleted, r.DriverID, deleteComment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
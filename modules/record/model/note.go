@@ -2,11 +2,13 @@ package model
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	valid "github.com/asaskevich/govalidator"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // INote 笔记接口
@@ -21,6 +23,8 @@ type Note struct {
 	Type      NoteType           `bson:"noteType" json:"noteType" valid:"required"`
 	Comment   string             `bson:"comment" json:"comment" valid:"-"`
 	CreatedAt time.Time          `bson:"createdAt" json:"createdAt" valid:"required"`
+	// 防篡改哈希链
+	ChainLink `bson:",inline" json:",inline"`
 }
 
 // SystemNote 系统笔记
@@ -37,7 +41,7 @@ func (sn *SystemNote) Add() error {
 	if _, err := noteCollection.InsertOne(context.TODO(), sn); err != nil {
 		return err
 	}
-	return nil
+	return sn.chainOrDiscard()
 }
 
 // OtherWorkNote 其它笔记
@@ -54,7 +58,7 @@ func (own *OtherWorkNote) Add() error {
 	if _, err := noteCollection.InsertOne(context.TODO(), own); err != nil {
 		return err
 	}
-	return nil
+	return own.chainOrDiscard()
 }
 
 // ModificationNote 人为修改笔记
@@ -72,12 +76,13 @@ func (mn *ModificationNote) Add() error {
 	if _, err := noteCollection.InsertOne(context.TODO(), mn); err != nil {
 		return err
 	}
-	return nil
+	return mn.chainOrDiscard()
 }
 
 // TripNote 行程笔记
 type TripNote struct {
 	Note                `bson:",inline"`
+	DriverID            primitive.ObjectID  `bson:"driverID" json:"driverID" valid:"required"`
 	TransportOperatorID *primitive.ObjectID `bson:"transportOperatorID,omitempty" json:"transportOperatorID,omitempty" valid:"-"`
 	StartTime           time.Time           `bson:"startTime" json:"startTime" valid:"required"`
 	EndTime             time.Time           `bson:"endTime" json:"endTime" valid:"required"`
@@ -87,16 +92,81 @@ type TripNote struct {
 
 // Add 行程笔记添加到数据库
 func (tn *TripNote) Add() error {
-	if _, err := valid.ValidateStruct(tn); err != nil {
+	if err := tn.beforeSave(); err != nil {
 		return err
 	}
 	// 数据库添加记录
 	if _, err := noteCollection.InsertOne(context.TODO(), tn); err != nil {
 		return err
 	}
+	return tn.chainOrDiscard()
+}
+
+// Update 行程笔记更新
+func (tn *TripNote) Update() error {
+	if err := tn.beforeSave(); err != nil {
+		return err
+	}
+	// 保留修改前的版本, 修改后的笔记重新链接到哈希链, 链接失败时恢复修改前的版本
+	nv, err := addNoteVersion(tn.ID)
+	if err != nil {
+		return err
+	}
+	tn.ChainLink = ChainLink{}
+	if _, err := noteCollection.ReplaceOne(context.TODO(), bson.M{"_id": tn.ID, "noteType": TRIPNOTE}, tn); err != nil {
+		return err
+	}
+	if err := tn.chain(); err != nil {
+		nv.revert()
+		return err
+	}
 	return nil
 }
 
+// beforeSave 补全行程起止位置并验证
+func (tn *TripNote) beforeSave() error {
+	if !tn.StartTime.Before(tn.EndTime) {
+		return errors.New("startTime should be before endTime")
+	}
+	if err := tn.StartLocation.fillFull(); err != nil {
+		return err
+	}
+	if err := tn.EndLocation.fillFull(); err != nil {
+		return err
+	}
+	_, err := valid.ValidateStruct(tn)
+	return err
+}
+
+// GetTripNote 通过id获取行程笔记
+func GetTripNote(id primitive.ObjectID) (*TripNote, error) {
+	tn := new(TripNote)
+	err := noteCollection.FindOne(context.TODO(), bson.M{"_id": id, "noteType": TRIPNOTE}).Decode(tn)
+	return tn, err
+}
+
+// GetTripNotes 获取司机时间段内的行程笔记, operatorID不为空时只返回该运输公司的行程
+func GetTripNotes(driverID primitive.ObjectID, from, to time.Time, operatorID *primitive.ObjectID) ([]TripNote, error) {
+	tripNotes := []TripNote{}
+	filter := bson.M{
+		"noteType":  TRIPNOTE,
+		"driverID":  driverID,
+		"startTime": bson.M{"$gte": from, "$lte": to},
+	}
+	if operatorID != nil {
+		filter["transportOperatorID"] = *operatorID
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "startTime", Value: 1}})
+	cursor, err := noteCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(context.TODO(), &tripNotes); err != nil {
+		return nil, err
+	}
+	return tripNotes, nil
+}
+
 type (
 	// DifNote 不同的笔记
 	DifNote bson.M
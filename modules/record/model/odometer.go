@@ -0,0 +1,245 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OdometerTolerance 相邻里程读数允许的误差(公里)
+const OdometerTolerance = 1.0
+
+// OdometerDiscrepancy 里程读数异常类型
+type OdometerDiscrepancy string
+
+const (
+	// ODOMETERGAP 里程读数出现空缺, 车辆在无记录的情况下行驶
+	ODOMETERGAP OdometerDiscrepancy = "gap"
+	// ODOMETERROLLBACK 里程读数回退
+	ODOMETERROLLBACK OdometerDiscrepancy = "rollback"
+)
+
+// OdometerReading 车辆最近一次已知的里程读数
+type OdometerReading struct {
+	VehicleID primitive.ObjectID `bson:"_id" json:"vehicleID"`
+	Reading   float64            `bson:"reading" json:"reading"`
+	Time      time.Time          `bson:"time" json:"time"`
+	RecordID  primitive.ObjectID `bson:"recordID" json:"recordID"`
+	DriverID  primitive.ObjectID `bson:"driverID" json:"driverID"`
+}
+
+// OdometerEntry 车辆里程时间线中的一条记录
+type OdometerEntry struct {
+	RecordID     primitive.ObjectID  `json:"recordID"`
+	DriverID     primitive.ObjectID  `json:"driverID"`
+	StartTime    time.Time           `json:"startTime"`
+	EndTime      time.Time           `json:"endTime"`
+	StartMileAge *float64            `json:"startDistance,omitempty"`
+	EndMileAge   *float64            `json:"endDistance,omitempty"`
+	Discrepancy  OdometerDiscrepancy `json:"discrepancy,omitempty"`
+	Difference   float64             `json:"difference,omitempty"`
+}
+
+// compareOdometer 比较前后两个里程读数
+func compareOdometer(before, after float64) (OdometerDiscrepancy, float64) {
+	diff := after - before
+	switch {
+	case diff < -OdometerTolerance:
+		return ODOMETERROLLBACK, diff
+	case diff > OdometerTolerance:
+		return ODOMETERGAP, diff
+	}
+	return "", 0
+}
+
+// trackOdometer 记录添加后检查车辆里程是否连续, 异常时添加系统笔记, 并更新车辆最近读数
+func (r *Record) trackOdometer() error {
+	if r.StartMileAge != nil {
+		prev, err := r.previousOdometer()
+		if err != nil {
+			return err
+		}
+		if prev != nil {
+			if comment := r.previousOdometerComment(prev); comment != "" {
+				if err := r.addSystemNote(comment); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if r.EndMileAge != nil {
+		next, err := r.nextOdometer()
+		if err != nil {
+			return err
+		}
+		if next != nil {
+			if d, diff := compareOdometer(*r.EndMileAge, *next.StartMileAge); d != "" {
+				comment := fmt.Sprintf("odometer %s of %.1f km before %.1f km recorded at %s",
+					d, diff, *next.StartMileAge, next.Time.Add(-next.Duration).In(loc).Format(time.RFC3339))
+				if err := r.addSystemNote(comment); err != nil {
+					return err
+				}
+			}
+		}
+		return r.updateOdometerReading()
+	}
+	return nil
+}
+
+// previousOdometerComment 比较记录开始里程与同一车辆上一读数, 异常时返回系统笔记内容
+func (r *Record) previousOdometerComment(prev *Record) string {
+	d, diff := compareOdometer(*prev.EndMileAge, *r.StartMileAge)
+	if d == "" {
+		return ""
+	}
+	return fmt.Sprintf("odometer %s of %.1f km since %.1f km recorded at %s",
+		d, diff, *prev.EndMileAge, prev.Time.In(loc).Format(time.RFC3339))
+}
+
+// addSystemNote 为记录添加系统笔记, 已有相同内容的笔记时跳过, 以免记录重新检查时重复添加
+func (r *Record) addSystemNote(comment string) error {
+	_, err := r.addSystemNoteOnce(comment)
+	return err
+}
+
+// addSystemNoteOnce 添加系统笔记并返回是否新添加
+func (r *Record) addSystemNoteOnce(comment string) (bool, error) {
+	filter := bson.M{"recordID": r.ID, "noteType": SYSTEMNOTE, "comment": comment}
+	count, err := noteCollection.CountDocuments(context.TODO(), filter)
+	if err != nil || count > 0 {
+		return false, err
+	}
+	sn := &SystemNote{
+		Note: Note{
+			ID:        primitive.NewObjectID(),
+			RecordID:  r.ID,
+			Type:      SYSTEMNOTE,
+			Comment:   comment,
+			CreatedAt: time.Now(),
+		},
+	}
+	return true, sn.Add()
+}
+
+// previousOdometer 获取同一车辆在该记录开始前最近一条有结束里程的记录, 不限司机
+func (r *Record) previousOdometer() (*Record, error) {
+	filter := bson.M{
+		"_id":         bson.M{"$ne": r.ID},
+		"vehicleID":   r.VehicleID,
+		"deletedAt":   nil,
+		"endDistance": bson.M{"$exists": true},
+		"time":        bson.M{"$lte": r.Time.Add(-r.Duration).Add(10 * time.Second)},
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "time", Value: -1}})
+	prev := new(Record)
+	err := recordCollection.FindOne(context.TODO(), filter, opts).Decode(prev)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return prev, err
+}
+
+// nextOdometer 获取同一车辆在该记录结束后最早一条有开始里程的记录, 用于检查补传的历史记录
+func (r *Record) nextOdometer() (*Record, error) {
+	filter := bson.M{
+		"_id":           bson.M{"$ne": r.ID},
+		"vehicleID":     r.VehicleID,
+		"deletedAt":     nil,
+		"startDistance": bson.M{"$exists": true},
+		"time":          bson.M{"$gt": r.Time},
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "time", Value: 1}})
+	next := new(Record)
+	err := recordCollection.FindOne(context.TODO(), filter, opts).Decode(next)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 仅当下一条记录在该记录结束后开始时才比较
+	if next.Time.Add(-next.Duration).Before(r.Time.Add(-10 * time.Second)) {
+		return nil, nil
+	}
+	return next, nil
+}
+
+// updateOdometerReading 若记录比车辆已知读数更新, 则更新车辆最近读数
+func (r *Record) updateOdometerReading() error {
+	// 修改后的记录可能早于原来的时间, 仍可更新由该记录写入的读数
+	filter := bson.M{"_id": r.VehicleID, "$or": bson.A{
+		bson.M{"time": bson.M{"$lt": r.Time}},
+		bson.M{"recordID": r.ID},
+	}}
+	update := bson.M{"$set": bson.M{
+		"reading":  *r.EndMileAge,
+		"time":     r.Time,
+		"recordID": r.ID,
+		"driverID": r.DriverID,
+	}}
+	_, err := odometerCollection.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+	// 已有更新的读数时upsert会与_id冲突, 忽略
+	if we, ok := err.(mongo.WriteException); ok {
+		for _, e := range we.WriteErrors {
+			if e.Code != duplicateKeyCode {
+				return err
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+// GetOdometerReading 获取车辆最近一次已知的里程读数
+func GetOdometerReading(vehicleID primitive.ObjectID) (*OdometerReading, error) {
+	or := new(OdometerReading)
+	err := odometerCollection.FindOne(context.TODO(), bson.M{"_id": vehicleID}).Decode(or)
+	return or, err
+}
+
+// GetOdometerTimeline 获取车辆时间段内所有司机的里程记录, 并标出与上一读数之间的异常
+func GetOdometerTimeline(vehicleID primitive.ObjectID, from, to time.Time) ([]OdometerEntry, error) {
+	filter := bson.M{
+		"vehicleID": vehicleID,
+		"deletedAt": nil,
+		"time":      bson.M{"$gte": from, "$lte": to},
+		"$or": bson.A{
+			bson.M{"startDistance": bson.M{"$exists": true}},
+			bson.M{"endDistance": bson.M{"$exists": true}},
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: 1}})
+	cursor, err := recordCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	records := []Record{}
+	if err = cursor.All(context.TODO(), &records); err != nil {
+		return nil, err
+	}
+
+	entries := make([]OdometerEntry, len(records))
+	var last *float64
+	for i, v := range records {
+		entries[i] = OdometerEntry{
+			RecordID:     v.ID,
+			DriverID:     v.DriverID,
+			StartTime:    v.Time.Add(-v.Duration),
+			EndTime:      v.Time,
+			StartMileAge: v.StartMileAge,
+			EndMileAge:   v.EndMileAge,
+		}
+		if last != nil && v.StartMileAge != nil {
+			entries[i].Discrepancy, entries[i].Difference = compareOdometer(*last, *v.StartMileAge)
+		}
+		if v.EndMileAge != nil {
+			last = v.EndMileAge
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,124 @@
+package model
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PageCursor 分页游标, 指向上一页最后一条记录
+type PageCursor struct {
+	Time time.Time
+	ID   primitive.ObjectID
+}
+
+// Encode 将游标编码为字符串
+func (pc *PageCursor) Encode() string {
+	s := pc.Time.UTC().Format(time.RFC3339Nano) + "|" + pc.ID.Hex()
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// DecodePageCursor 解析游标字符串
+func DecodePageCursor(s string) (*PageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	parts := strings.Split(string(b), "|")
+	if len(parts) != 2 {
+		return nil, errors.New("invalid cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	id, err := primitive.ObjectIDFromHex(parts[1])
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &PageCursor{Time: t, ID: id}, nil
+}
+
+// RecordFilter 记录查询条件
+type RecordFilter struct {
+	DriverID   primitive.ObjectID
+	From       time.Time
+	To         time.Time
+	Type       Type
+	VehicleID  *primitive.ObjectID
+	HasNotes   *bool
+	GetDeleted bool
+	After      *PageCursor
+	Limit      int
+}
+
+// RecordWithNotes 带笔记的记录
+type RecordWithNotes struct {
+	Record `bson:",inline"`
+	Notes  DifNotes `bson:"notes"`
+}
+
+// FindRecords 按条件分页获取记录及其笔记, 按time和_id升序排列, Limit不大于0时不限制条数
+func FindRecords(f *RecordFilter) ([]RecordWithNotes, error) {
+	match := bson.M{
+		"driverID": f.DriverID,
+		"time":     bson.M{"$gte": f.From, "$lte": f.To},
+	}
+	if !f.GetDeleted {
+		match["deletedAt"] = nil
+	}
+	if f.Type != "" {
+		match["type"] = f.Type
+	}
+	if f.VehicleID != nil {
+		match["vehicleID"] = *f.VehicleID
+	}
+	if f.After != nil {
+		match["$or"] = bson.A{
+			bson.M{"time": bson.M{"$gt": f.After.Time}},
+			bson.M{"time": f.After.Time, "_id": bson.M{"$gt": f.After.ID}},
+		}
+	}
+
+	lookup := bson.D{{Key: "$lookup", Value: bson.M{
+		"from":         noteCollection.Name(),
+		"localField":   "_id",
+		"foreignField": "recordID",
+		"as":           "notes",
+	}}}
+	pipeline := []bson.D{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}}}},
+	}
+	limit := []bson.D{}
+	if f.Limit > 0 {
+		limit = append(limit, bson.D{{Key: "$limit", Value: f.Limit}})
+	}
+	// 按是否有笔记筛选时需要先关联笔记再限制条数
+	if f.HasNotes != nil {
+		notesMatch := bson.M{"notes": bson.M{"$ne": bson.A{}}}
+		if !*f.HasNotes {
+			notesMatch = bson.M{"notes": bson.A{}}
+		}
+		pipeline = append(pipeline, lookup, bson.D{{Key: "$match", Value: notesMatch}})
+		pipeline = append(pipeline, limit...)
+	} else {
+		pipeline = append(pipeline, limit...)
+		pipeline = append(pipeline, lookup)
+	}
+
+	records := []RecordWithNotes{}
+	cursor, err := recordCollection.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(context.TODO(), &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
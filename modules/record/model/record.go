@@ -3,6 +3,7 @@ package model
 import (
 	"context"
 	"errors"
+	"log"
 	"math"
 	"time"
 
@@ -14,7 +15,6 @@ import (
 	valid "github.com/asaskevich/govalidator"
 
 	locModel "github.com/chadhao/logit/modules/location/model"
-	"github.com/chadhao/logit/utils"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -28,11 +28,33 @@ type (
 )
 
 const (
-	// WORK 工作记录类型
-	WORK Type = "work"
+	// DRIVING 驾驶记录类型
+	DRIVING Type = "driving"
+	// OTHERWORK 其它工作记录类型, 如装卸货、文书工作
+	OTHERWORK Type = "other_work"
 	// REST 休息记录类型
 	REST Type = "rest"
+	// OFFDUTY 下班记录类型
+	OFFDUTY Type = "off_duty"
+	// WORK 旧版工作记录类型, 已迁移为DRIVING或OTHERWORK, 仅存在于历史版本及迁移后恢复的归档记录中
+	WORK Type = "work"
 )
+
+// Valid 是否为可添加的记录类型
+func (t Type) Valid() bool {
+	switch t {
+	case DRIVING, OTHERWORK, REST, OFFDUTY:
+		return true
+	}
+	return false
+}
+
+// deleteComment 删除记录时生成的修改笔记内容
+const deleteComment = "record deleted"
+
+// duplicateKeyCode 数据库唯一索引冲突错误码
+const duplicateKeyCode = 11000
+
 const (
 	// SYSTEMNOTE 系统笔记类型
 	SYSTEMNOTE NoteType = "system"
@@ -103,9 +125,17 @@ type Record struct {
 	VehicleID     primitive.ObjectID `bson:"vehicleID" json:"vehicleID" valid:"required"`
 	StartMileAge  *float64           `bson:"startDistance,omitempty" json:"startDistance,omitempty" valid:"-"`
 	EndMileAge    *float64           `bson:"endDistance,omitempty" json:"endDistance,omitempty" valid:"-"`
+	GPSDistance   *float64           `bson:"gpsDistance,omitempty" json:"gpsDistance,omitempty" valid:"-"`
 	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt" valid:"required"`
 	ClientTime    *time.Time         `bson:"clientTime,omitempty" json:"clientTime,omitempty" valid:"-"`
 	DeletedAt     *time.Time         `bson:"deletedAt,omitempty" json:"deletedAt,omitempty" valid:"-"`
+	Version       int                `bson:"version" json:"version" valid:"-"`
+	ClientID      string             `bson:"clientID,omitempty" json:"clientID,omitempty" valid:"-"`
+	// 双人驾驶
+	TeamTripID      *primitive.ObjectID `bson:"teamTripID,omitempty" json:"teamTripID,omitempty" valid:"-"`
+	InMovingVehicle bool                `bson:"inMovingVehicle,omitempty" json:"inMovingVehicle,omitempty" valid:"-"`
+	// 防篡改哈希链
+	ChainLink `bson:",inline" json:",inline"`
 }
 
 // Add 记录添加
@@ -120,48 +150,62 @@ func (r *Record) Add() (err error) {
 		return
 	}
 
-	// 数据库添加记录
+	// 数据库添加记录, 未能链接到哈希链时撤销添加
 	if _, err = recordCollection.InsertOne(context.TODO(), r); err != nil {
 		return
 	}
-	return
+	if err = r.chain(); err != nil {
+		discardUnchained(recordCollection, r.ID)
+		return
+	}
+	r.afterAdd()
+	if err := r.updateSummaries(); err != nil {
+		log.Println("record summaries:", r.ID.Hex(), err)
+	}
+	return nil
+}
+
+// afterAdd 记录链接到哈希链后的检查, 记录已保存, 失败时只记录日志, 以免客户端重试时与已写入的记录冲突
+func (r *Record) afterAdd() {
+	// 按车辆检查里程连续性, 里程可能由不同司机记录
+	if err := r.trackOdometer(); err != nil {
+		log.Println("record odometer:", r.ID.Hex(), err)
+	}
+	// 与行驶位置计算的里程比较
+	if err := r.checkGPSDistance(); err != nil {
+		log.Println("record gps distance:", r.ID.Hex(), err)
+	}
 }
 
-// Delete 记录删除
+// Delete 记录删除, 删除标记参与哈希计算, 与修改相同, 原记录保存为历史版本, 删除后的记录重新链接到哈希链
 func (r *Record) Delete() error {
+	if _, err := r.resumeAmend(); err != nil {
+		return err
+	}
 	switch {
 	case r.DeletedAt != nil:
 		return errors.New("record has already been deleted")
 	case !r.isLatestRecord():
 		return errors.New("record is not the lastest one")
 	}
-	// 数据库记录添加删除标记
-	update := bson.M{"$set": bson.M{"deletedAt": time.Now()}}
-	if _, err := recordCollection.UpdateOne(context.TODO(), bson.M{"_id": r.ID}, update); err != nil {
-		return err
-	}
-	return nil
+	deleted := *r
+	now := time.Now()
+	deleted.DeletedAt = &now
+	_, err := r.replaceVersion(&deleted, r.DriverID, deleteComment)
+	return err
 }
 
 func (r *Record) beforeAdd(lastRec *Record) error {
 	if (Record{}) != *lastRec {
-		if lastRec.Type == r.Type {
-			return errors.New("work type conflict with last record")
-		}
-		if lastRec.Time.After(r.Time) {
-			return errors.New("time conflict with last record")
-		}
-		if !r.StartLocation.equal(&lastRec.EndLocation) {
-			return errors.New("location not match")
-		}
-		if r.StartMileAge != nil && !utils.AlmostEqual(*r.StartMileAge, *lastRec.EndMileAge) {
-			return errors.New("mileage not match")
-		}
-		if math.Abs(lastRec.Time.Add(r.Duration).Sub(r.Time).Seconds()) > 10 {
-			return errors.New("time and duration not match")
+		if err := r.checkContinuity(lastRec); err != nil {
+			return err
 		}
 	}
 
+	if err := r.validTeamTrip(); err != nil {
+		return err
+	}
+
 	if err := r.EndLocation.fillFull(); err != nil {
 		return err
 	}
@@ -169,12 +213,32 @@ func (r *Record) beforeAdd(lastRec *Record) error {
 	return r.valid()
 }
 
+// checkContinuity 检查记录与上一条记录是否衔接
+func (r *Record) checkContinuity(lastRec *Record) error {
+	if lastRec.Type == r.Type {
+		return errors.New("work type conflict with last record")
+	}
+	if lastRec.Time.After(r.Time) {
+		return errors.New("time conflict with last record")
+	}
+	if !r.StartLocation.equal(&lastRec.EndLocation) {
+		return errors.New("location not match")
+	}
+	if math.Abs(lastRec.Time.Add(r.Duration).Sub(r.Time).Seconds()) > 10 {
+		return errors.New("time and duration not match")
+	}
+	return nil
+}
+
 func (r *Record) valid() error {
 	// 1. 验证记录结构是否完整
 	if _, err := valid.ValidateStruct(r); err != nil {
 		return err
 	}
 	// 2. 验证记录内容, TBC...
+	if !r.Type.Valid() {
+		return errors.New("no match type")
+	}
 	return nil
 }
 
@@ -211,7 +275,8 @@ func GetRecords(driverID primitive.ObjectID, from, to time.Time, getDeleted bool
 	if !getDeleted {
 		filter["deletedAt"] = nil
 	}
-	cursor, err := recordCollection.Find(context.TODO(), filter)
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: 1}})
+	cursor, err := recordCollection.Find(context.TODO(), filter, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -224,28 +289,157 @@ func GetRecords(driverID primitive.ObjectID, from, to time.Time, getDeleted bool
 // Records .
 type Records []Record
 
-// SyncAdd 批量上传添加
-func (rs Records) SyncAdd() (err error) {
+// SyncStatus 同步结果状态
+type SyncStatus string
 
-	lastRec, err := GetLastestRecord(rs[0].DriverID)
+const (
+	// SYNCACCEPTED 记录已添加
+	SYNCACCEPTED SyncStatus = "accepted"
+	// SYNCDUPLICATE 记录此前已添加, 跳过
+	SYNCDUPLICATE SyncStatus = "duplicate"
+	// SYNCREJECTED 记录被拒绝
+	SYNCREJECTED SyncStatus = "rejected"
+)
+
+// SyncResult 单条记录的同步结果
+type SyncResult struct {
+	ClientID string     `json:"clientID"`
+	Status   SyncStatus `json:"status"`
+	Reason   string     `json:"reason,omitempty"`
+	Record   *Record    `json:"record,omitempty"`
+}
+
+// SyncAdd 批量上传添加, 按clientID跳过已添加的记录, 返回与rs顺序一致的逐条结果
+func (rs Records) SyncAdd() ([]SyncResult, error) {
+	results := make([]SyncResult, len(rs))
+	if len(rs) == 0 {
+		return results, nil
+	}
+	driverID := rs[0].DriverID
+
+	// 已存在的clientID
+	clientIDs := []string{}
+	for _, v := range rs {
+		clientIDs = append(clientIDs, v.ClientID)
+	}
+	existing, err := getClientIDs(driverID, clientIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	lastRec, err := GetLastestRecord(driverID)
 	if err != nil && err != mongo.ErrNoDocuments {
-		return err
+		return nil, err
 	}
-	l := len(rs)
-	for i := 0; i < l; i++ {
-		if err = rs[i].beforeAdd(lastRec); err != nil {
-			return
+	// 记录依次衔接, 第一条被拒绝的记录之后的记录全部拒绝
+	accepted := []int{}
+	rejected := false
+	for i := range rs {
+		results[i] = SyncResult{ClientID: rs[i].ClientID, Record: &rs[i]}
+		switch {
+		case rejected:
+			results[i].Status, results[i].Reason = SYNCREJECTED, "previous record rejected"
+			continue
+		case rs[i].DriverID != driverID:
+			results[i].Status, results[i].Reason = SYNCREJECTED, "driver not match"
+			rejected = true
+			continue
+		case existing[rs[i].ClientID]:
+			results[i].Status, results[i].Record = SYNCDUPLICATE, nil
+			continue
 		}
+		if err := rs[i].beforeAdd(lastRec); err != nil {
+			results[i].Status, results[i].Reason = SYNCREJECTED, err.Error()
+			rejected = true
+			continue
+		}
+		results[i].Status = SYNCACCEPTED
+		existing[rs[i].ClientID] = true
+		accepted = append(accepted, i)
 		lastRec = &rs[i]
 	}
+	if len(accepted) == 0 {
+		return results, nil
+	}
 
-	// 数据库添加记录
-	rsI := make([]interface{}, l)
-	for i := range rs {
-		rsI[i] = rs[i]
+	// 数据库按顺序添加记录, 并发重复提交导致的唯一索引冲突视为重复并继续,
+	// 其它错误停止添加并拒绝之后的记录, 以免记录之间出现空缺
+	for pending := accepted; len(pending) > 0; {
+		rsI := make([]interface{}, len(pending))
+		for i, v := range pending {
+			rsI[i] = rs[v]
+		}
+		_, err = recordCollection.InsertMany(context.TODO(), rsI, options.InsertMany().SetOrdered(true))
+		bwe, ok := err.(mongo.BulkWriteException)
+		if !ok {
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		if len(bwe.WriteErrors) == 0 {
+			return nil, err
+		}
+		we := bwe.WriteErrors[0]
+		if i := pending[we.Index]; we.Code == duplicateKeyCode {
+			results[i].Status, results[i].Record = SYNCDUPLICATE, nil
+		} else {
+			results[i].Status, results[i].Reason = SYNCREJECTED, we.Message
+			for _, j := range pending[we.Index+1:] {
+				results[j].Status, results[j].Reason = SYNCREJECTED, "previous record rejected"
+			}
+			break
+		}
+		pending = pending[we.Index+1:]
 	}
-	if _, err = recordCollection.InsertMany(context.TODO(), rsI); err != nil {
-		return
+	// 按顺序链接到哈希链, 链接失败时撤销该记录及之后添加的记录
+	var from, to time.Time
+	for n, i := range accepted {
+		if results[i].Status != SYNCACCEPTED {
+			continue
+		}
+		if err := rs[i].chain(); err != nil {
+			ids := []primitive.ObjectID{}
+			for _, j := range accepted[n:] {
+				if results[j].Status == SYNCACCEPTED {
+					ids = append(ids, rs[j].ID)
+					results[j].Status, results[j].Reason = SYNCREJECTED, "previous record rejected"
+				}
+			}
+			results[i].Reason = err.Error()
+			discardUnchained(recordCollection, ids...)
+			break
+		}
+		rs[i].afterAdd()
+		if start := rs[i].Time.Add(-rs[i].Duration); from.IsZero() || start.Before(from) {
+			from = start
+		}
+		if rs[i].Time.After(to) {
+			to = rs[i].Time
+		}
 	}
-	return
+	if !from.IsZero() {
+		if err := UpdateSummaries(driverID, from, to); err != nil {
+			log.Println("record summaries:", driverID.Hex(), err)
+		}
+	}
+	return results, nil
+}
+
+// getClientIDs 获取司机已添加记录中存在的clientID
+func getClientIDs(driverID primitive.ObjectID, clientIDs []string) (map[string]bool, error) {
+	existing := make(map[string]bool)
+	opts := options.Find().SetProjection(bson.M{"clientID": 1})
+	cursor, err := recordCollection.Find(context.TODO(), bson.M{"driverID": driverID, "clientID": bson.M{"$in": clientIDs}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	records := []Record{}
+	if err = cursor.All(context.TODO(), &records); err != nil {
+		return nil, err
+	}
+	for _, v := range records {
+		existing[v.ClientID] = true
+	}
+	return existing, nil
 }
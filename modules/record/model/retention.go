@@ -0,0 +1,557 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	locModel "github.com/chadhao/logit/modules/location/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// MinRetentionMonths 日志依法至少保存12个月
+	MinRetentionMonths = 12
+	// defaultRestoreDays 恢复的归档数据默认保留天数
+	defaultRestoreDays = 7
+)
+
+// archiveKind 归档文件中数据的种类
+type archiveKind string
+
+const (
+	archiveRecord      archiveKind = "record"
+	archiveNote        archiveKind = "note"
+	archiveVersion     archiveKind = "record_version"
+	archiveNoteVersion archiveKind = "note_version"
+	archiveDrivingLoc  archiveKind = "driving_location"
+)
+
+// ArchiveState 归档清单的状态
+type ArchiveState string
+
+const (
+	// ARCHIVEPURGING 归档文件已写入, 原数据尚未全部删除
+	ARCHIVEPURGING ArchiveState = "purging"
+	// ARCHIVED 原数据已删除, 早于状态字段的清单没有状态, 同样视为已完成
+	ARCHIVED ArchiveState = "archived"
+)
+
+// ArchiveManifest 归档清单, 同时写入归档存储和数据库
+type ArchiveManifest struct {
+	ID            primitive.ObjectID `bson:"_id" json:"id"`
+	DriverID      primitive.ObjectID `bson:"driverID" json:"driverID"`
+	From          time.Time          `bson:"from" json:"from"`
+	To            time.Time          `bson:"to" json:"to"`
+	Name          string             `bson:"name" json:"name"`
+	Records       int                `bson:"records" json:"records"`
+	Notes         int                `bson:"notes" json:"notes"`
+	Versions      int                `bson:"versions" json:"versions"`
+	DrivingLocs   int                `bson:"drivingLocs" json:"drivingLocs"`
+	Size          int64              `bson:"size" json:"size"`
+	SHA256        string             `bson:"sha256" json:"sha256"`
+	Chain         []ChainRange       `bson:"chain,omitempty" json:"chain,omitempty"`
+	State         ArchiveState       `bson:"state,omitempty" json:"state,omitempty"`
+	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
+	RestoredAt    *time.Time         `bson:"restoredAt,omitempty" json:"restoredAt,omitempty"`
+	RestoredUntil *time.Time         `bson:"restoredUntil,omitempty" json:"restoredUntil,omitempty"`
+}
+
+// archiveEntry 归档文件中的一行
+type archiveEntry struct {
+	Kind archiveKind `bson:"kind"`
+	Doc  bson.Raw    `bson:"doc"`
+}
+
+// archiveData 一个归档包含的全部数据
+type archiveData map[archiveKind][]bson.Raw
+
+var retentionMu sync.Mutex
+
+// retentionMonths 配置的保存月数, 不得少于法定的12个月
+func retentionMonths() int {
+	months, err := strconv.Atoi(config["record.retention.months"])
+	if err != nil || months < MinRetentionMonths {
+		return MinRetentionMonths
+	}
+	return months
+}
+
+// monthStart 返回t在时区l中所在月份的第一天
+func monthStart(t time.Time, l *time.Location) time.Time {
+	t = t.In(l)
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, l)
+}
+
+// StartRetention 按interval定时执行归档任务, 未配置归档存储时不执行
+func StartRetention(interval time.Duration) {
+	if archiveStore == nil {
+		return
+	}
+	retentionTicker = time.NewTicker(interval)
+	go func() {
+		for range retentionTicker.C {
+			if _, err := RunRetention(time.Now()); err != nil {
+				log.Println("record retention:", err)
+			}
+		}
+	}()
+}
+
+// RunRetention 将保存期限以前的记录、笔记、历史版本及行驶位置按司机和月份归档后从数据库删除,
+// 并清除已过期的恢复数据, 返回本次新建的归档清单
+func RunRetention(now time.Time) ([]ArchiveManifest, error) {
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+	if archiveStore == nil {
+		return nil, errors.New("archive storage not configured")
+	}
+	if err := purgeExpiredRestores(now); err != nil {
+		return nil, err
+	}
+	// 先完成上次中断的删除, 以免剩余的原数据再次归档
+	if err := resumePurges(bson.M{}); err != nil {
+		return nil, err
+	}
+
+	cutoff := monthStart(now, loc).AddDate(0, -retentionMonths(), 0)
+	driverIDs, err := retentionDriverIDs(cutoff)
+	if err != nil {
+		return nil, err
+	}
+	manifests := []ArchiveManifest{}
+	for _, driverID := range driverIDs {
+		ms, err := archiveDriver(driverID, cutoff, now)
+		if err != nil {
+			return manifests, err
+		}
+		manifests = append(manifests, ms...)
+	}
+	return manifests, nil
+}
+
+// retentionDriverIDs 获取在cutoff之前有记录或行驶位置的司机
+func retentionDriverIDs(cutoff time.Time) ([]primitive.ObjectID, error) {
+	values, err := recordCollection.Distinct(context.TODO(), "driverID", bson.M{"time": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[primitive.ObjectID]bool)
+	driverIDs := []primitive.ObjectID{}
+	for _, v := range values {
+		if id, ok := v.(primitive.ObjectID); ok && !seen[id] {
+			seen[id] = true
+			driverIDs = append(driverIDs, id)
+		}
+	}
+	locDriverIDs, err := locModel.GetDrivingLocDriverIDs(cutoff)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range locDriverIDs {
+		if !seen[id] {
+			seen[id] = true
+			driverIDs = append(driverIDs, id)
+		}
+	}
+	return driverIDs, nil
+}
+
+// archiveDriver 按司机本地时区的月份归档其cutoff之前的数据, 跳过仍处于恢复期的月份
+func archiveDriver(driverID primitive.ObjectID, cutoff, now time.Time) ([]ArchiveManifest, error) {
+	l := DriverLocation(driverID)
+	cutoff = monthStart(cutoff, l)
+	first := cutoff
+	r := new(Record)
+	opts := options.FindOne().SetSort(bson.D{{Key: "time", Value: 1}})
+	err := recordCollection.FindOne(context.TODO(), bson.M{"driverID": driverID}, opts).Decode(r)
+	switch {
+	case err == nil && r.Time.Before(first):
+		first = r.Time
+	case err != nil && err != mongo.ErrNoDocuments:
+		return nil, err
+	}
+	dl, err := locModel.GetFirstDrivingLoc(driverID)
+	switch {
+	case err == nil && dl.CreatedAt.Before(first):
+		first = dl.CreatedAt
+	case err != nil && err != mongo.ErrNoDocuments:
+		return nil, err
+	}
+
+	manifests := []ArchiveManifest{}
+	for from := monthStart(first, l); from.Before(cutoff); from = from.AddDate(0, 1, 0) {
+		to := from.AddDate(0, 1, 0)
+		restored, err := manifestCollection.CountDocuments(context.TODO(), bson.M{
+			"driverID":      driverID,
+			"from":          bson.M{"$lt": to},
+			"to":            bson.M{"$gt": from},
+			"restoredUntil": bson.M{"$gt": now},
+		})
+		if err != nil {
+			return manifests, err
+		}
+		if restored > 0 {
+			continue
+		}
+		m, err := archiveMonth(driverID, from, to)
+		if err != nil {
+			return manifests, err
+		}
+		if m != nil {
+			manifests = append(manifests, *m)
+		}
+	}
+	return manifests, nil
+}
+
+// findRaw 查询并返回原始文档
+func findRaw(collection *mongo.Collection, filter bson.M) ([]bson.Raw, error) {
+	cursor, err := collection.Find(context.TODO(), filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	docs := []bson.Raw{}
+	for cursor.Next(context.TODO()) {
+		doc := make(bson.Raw, len(cursor.Current))
+		copy(doc, cursor.Current)
+		docs = append(docs, doc)
+	}
+	return docs, cursor.Err()
+}
+
+// rawIDs 获取原始文档的_id
+func rawIDs(docs []bson.Raw) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(docs))
+	for _, doc := range docs {
+		if id, ok := doc.Lookup("_id").ObjectIDOK(); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// archiveMonth 归档司机[from, to)内的数据, 写入归档文件和删除中的清单后删除原数据, 无数据时返回nil
+func archiveMonth(driverID primitive.ObjectID, from, to time.Time) (*ArchiveManifest, error) {
+	data := archiveData{}
+	var err error
+	if data[archiveRecord], err = findRaw(recordCollection, bson.M{
+		"driverID": driverID,
+		"time":     bson.M{"$gte": from, "$lt": to},
+	}); err != nil {
+		return nil, err
+	}
+	recordIDs := rawIDs(data[archiveRecord])
+	if len(recordIDs) > 0 {
+		if data[archiveNote], err = findRaw(noteCollection, bson.M{"recordID": bson.M{"$in": recordIDs}}); err != nil {
+			return nil, err
+		}
+		if data[archiveVersion], err = findRaw(historyCollection, bson.M{"recordID": bson.M{"$in": recordIDs}}); err != nil {
+			return nil, err
+		}
+		if data[archiveNoteVersion], err = findRaw(noteHistoryCollection, bson.M{"recordID": bson.M{"$in": recordIDs}}); err != nil {
+			return nil, err
+		}
+	}
+	drivingLocs, err := locModel.FindDrivingLocs(driverID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range drivingLocs {
+		doc, err := bson.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		data[archiveDrivingLoc] = append(data[archiveDrivingLoc], doc)
+	}
+	if len(data[archiveRecord]) == 0 && len(data[archiveDrivingLoc]) == 0 {
+		return nil, nil
+	}
+
+	body, err := data.encode()
+	if err != nil {
+		return nil, err
+	}
+	// 记录归档节点的区间, 验证哈希链时衔接删除后的空缺
+	entries, err := data.chainEntries()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	m := &ArchiveManifest{
+		ID:          primitive.NewObjectID(),
+		DriverID:    driverID,
+		From:        from,
+		To:          to,
+		Records:     len(data[archiveRecord]),
+		Notes:       len(data[archiveNote]),
+		Versions:    len(data[archiveVersion]) + len(data[archiveNoteVersion]),
+		DrivingLocs: len(data[archiveDrivingLoc]),
+		Size:        int64(len(body)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		Chain:       chainRanges(entries),
+		State:       ARCHIVEPURGING,
+		CreatedAt:   time.Now(),
+	}
+	m.Name = fmt.Sprintf("%s/%s-%s.ndjson.gz", driverID.Hex(), from.Format("2006-01"), m.ID.Hex())
+
+	// 归档文件和清单写入成功后才删除原数据
+	if err = archiveStore.Put(m.Name, bytes.NewReader(body)); err != nil {
+		return nil, err
+	}
+	if _, err = manifestCollection.InsertOne(context.TODO(), m); err != nil {
+		return nil, err
+	}
+	if err = m.complete(data); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// complete 删除已归档的原数据, 写入清单文件后标记归档完成, 中途失败时由下一次归档任务继续
+func (m *ArchiveManifest) complete(data archiveData) error {
+	if err := data.purge(); err != nil {
+		return err
+	}
+	m.State = ARCHIVED
+	manifestJSON, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(m.Name, ".ndjson.gz") + ".manifest.json"
+	if err = archiveStore.Put(name, bytes.NewReader(manifestJSON)); err != nil {
+		return err
+	}
+	update := bson.M{"$set": bson.M{"state": ARCHIVED}}
+	_, err = manifestCollection.UpdateOne(context.TODO(), bson.M{"_id": m.ID}, update)
+	return err
+}
+
+// resumePurges 重新读取删除中断的清单对应的归档文件, 完成原数据的删除
+func resumePurges(filter bson.M) error {
+	filter["state"] = ARCHIVEPURGING
+	manifests, err := findManifests(filter)
+	if err != nil {
+		return err
+	}
+	for i := range manifests {
+		data, err := manifests[i].load()
+		if err != nil {
+			return err
+		}
+		if err = manifests[i].complete(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encode 将归档数据编码为gzip压缩的扩展JSON, 每行一个文档
+func (data archiveData) encode() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	for _, kind := range []archiveKind{archiveRecord, archiveNote, archiveVersion, archiveNoteVersion, archiveDrivingLoc} {
+		for _, doc := range data[kind] {
+			line, err := bson.MarshalExtJSON(&archiveEntry{Kind: kind, Doc: doc}, true, false)
+			if err != nil {
+				return nil, err
+			}
+			if _, err = zw.Write(append(line, '\n')); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeArchive 解析归档文件
+func decodeArchive(r io.Reader) (archiveData, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	data := archiveData{}
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		entry := new(archiveEntry)
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), true, entry); err != nil {
+			return nil, err
+		}
+		data[entry.Kind] = append(data[entry.Kind], entry.Doc)
+	}
+	return data, scanner.Err()
+}
+
+// purge 从数据库删除归档数据对应的原数据
+func (data archiveData) purge() error {
+	collections := map[archiveKind]*mongo.Collection{
+		archiveRecord:      recordCollection,
+		archiveNote:        noteCollection,
+		archiveVersion:     historyCollection,
+		archiveNoteVersion: noteHistoryCollection,
+	}
+	for kind, collection := range collections {
+		if ids := rawIDs(data[kind]); len(ids) > 0 {
+			if _, err := collection.DeleteMany(context.TODO(), bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+				return err
+			}
+		}
+	}
+	return locModel.DeleteDrivingLocs(rawIDs(data[archiveDrivingLoc]))
+}
+
+// restore 将归档数据写回数据库, 已存在的文档跳过
+func (data archiveData) restore() error {
+	collections := map[archiveKind]*mongo.Collection{
+		archiveRecord:      recordCollection,
+		archiveNote:        noteCollection,
+		archiveVersion:     historyCollection,
+		archiveNoteVersion: noteHistoryCollection,
+	}
+	for kind, collection := range collections {
+		if len(data[kind]) == 0 {
+			continue
+		}
+		docs := make([]interface{}, len(data[kind]))
+		for i, v := range data[kind] {
+			docs[i] = v
+		}
+		_, err := collection.InsertMany(context.TODO(), docs, options.InsertMany().SetOrdered(false))
+		if bwe, ok := err.(mongo.BulkWriteException); ok {
+			for _, we := range bwe.WriteErrors {
+				if we.Code != duplicateKeyCode {
+					return err
+				}
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+	drivingLocs := make([]locModel.DrivingLoc, len(data[archiveDrivingLoc]))
+	for i, v := range data[archiveDrivingLoc] {
+		if err := bson.Unmarshal(v, &drivingLocs[i]); err != nil {
+			return err
+		}
+	}
+	return locModel.RestoreDrivingLocs(drivingLocs)
+}
+
+// load 读取并校验清单对应的归档文件
+func (m *ArchiveManifest) load() (archiveData, error) {
+	rc, err := archiveStore.Get(m.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	body, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != m.SHA256 {
+		return nil, errors.New("archive checksum not match")
+	}
+	return decodeArchive(bytes.NewReader(body))
+}
+
+// purgeExpiredRestores 再次删除恢复期已过的归档数据
+func purgeExpiredRestores(now time.Time) error {
+	manifests, err := findManifests(bson.M{"restoredUntil": bson.M{"$lte": now}})
+	if err != nil {
+		return err
+	}
+	for _, m := range manifests {
+		data, err := m.load()
+		if err != nil {
+			return err
+		}
+		if err = data.purge(); err != nil {
+			return err
+		}
+		update := bson.M{"$unset": bson.M{"restoredUntil": ""}}
+		if _, err = manifestCollection.UpdateOne(context.TODO(), bson.M{"_id": m.ID}, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreArchives 恢复司机在时间段内的归档数据, 恢复的数据保留days天后由归档任务再次删除
+func RestoreArchives(driverID primitive.ObjectID, from, to time.Time, days int) ([]ArchiveManifest, error) {
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+	if archiveStore == nil {
+		return nil, errors.New("archive storage not configured")
+	}
+	if days <= 0 {
+		days = defaultRestoreDays
+	}
+	// 删除中断的归档先完成删除, 再按恢复期恢复
+	if err := resumePurges(bson.M{"driverID": driverID}); err != nil {
+		return nil, err
+	}
+	manifests, err := findManifests(bson.M{
+		"driverID": driverID,
+		"from":     bson.M{"$lt": to},
+		"to":       bson.M{"$gt": from},
+	})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	until := now.AddDate(0, 0, days)
+	for i := range manifests {
+		data, err := manifests[i].load()
+		if err != nil {
+			return nil, err
+		}
+		if err = data.restore(); err != nil {
+			return nil, err
+		}
+		update := bson.M{"$set": bson.M{"restoredAt": now, "restoredUntil": until}}
+		if _, err = manifestCollection.UpdateOne(context.TODO(), bson.M{"_id": manifests[i].ID}, update); err != nil {
+			return nil, err
+		}
+		manifests[i].RestoredAt, manifests[i].RestoredUntil = &now, &until
+	}
+	return manifests, nil
+}
+
+// GetArchiveManifests 获取司机的归档清单
+func GetArchiveManifests(driverID primitive.ObjectID) ([]ArchiveManifest, error) {
+	return findManifests(bson.M{"driverID": driverID})
+}
+
+func findManifests(filter bson.M) ([]ArchiveManifest, error) {
+	manifests := []ArchiveManifest{}
+	opts := options.Find().SetSort(bson.D{{Key: "from", Value: 1}})
+	cursor, err := manifestCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(context.TODO(), &manifests); err != nil {
+		return nil, err
+	}
+	return manifests, nil
+}
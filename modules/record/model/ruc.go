@@ -0,0 +1,180 @@
+package model
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RUCSegment 一条有起止里程的记录
+type RUCSegment struct {
+	RecordID     primitive.ObjectID `json:"recordID"`
+	DriverID     primitive.ObjectID `json:"driverID"`
+	StartTime    time.Time          `json:"startTime"`
+	EndTime      time.Time          `json:"endTime"`
+	StartMileAge float64            `json:"startDistance"`
+	EndMileAge   float64            `json:"endDistance"`
+	Distance     float64            `json:"distance"`
+}
+
+// RUCFlag 相邻记录之间的里程空缺(gap)或重叠(rollback), Distance为空缺或重叠的里程
+type RUCFlag struct {
+	Discrepancy  OdometerDiscrepancy `json:"discrepancy"`
+	AfterRecord  primitive.ObjectID  `json:"afterRecordID"`
+	BeforeRecord primitive.ObjectID  `json:"beforeRecordID"`
+	From         float64             `json:"fromDistance"`
+	To           float64             `json:"toDistance"`
+	Distance     float64             `json:"distance"`
+}
+
+// RUCProjection 按报告期间的日均里程预计RUC许可用完的时间
+type RUCProjection struct {
+	LicenceFrom   float64    `json:"licenceFrom"`
+	LicenceTo     float64    `json:"licenceTo"`
+	Reading       float64    `json:"reading"`
+	ReadingTime   time.Time  `json:"readingTime"`
+	Remaining     float64    `json:"remaining"`
+	DailyDistance float64    `json:"dailyDistance"`
+	ExhaustedAt   *time.Time `json:"exhaustedAt,omitempty"`
+}
+
+// RUCReport 车辆时间段内的里程报告, Distance为里程表走过的距离, 即应缴RUC的距离
+type RUCReport struct {
+	VehicleID          primitive.ObjectID `json:"vehicleID"`
+	From               time.Time          `json:"from"`
+	To                 time.Time          `json:"to"`
+	StartMileAge       *float64           `json:"startDistance,omitempty"`
+	EndMileAge         *float64           `json:"endDistance,omitempty"`
+	Distance           float64            `json:"distance"`
+	RecordedDistance   float64            `json:"recordedDistance"`
+	UnrecordedDistance float64            `json:"unrecordedDistance"`
+	OverlapDistance    float64            `json:"overlapDistance"`
+	Segments           []RUCSegment       `json:"segments"`
+	Flags              []RUCFlag          `json:"flags"`
+	Projection         *RUCProjection     `json:"projection,omitempty"`
+}
+
+// GetRUCReport 根据所有司机在该车辆上的记录生成时间段内的里程报告, 标出记录之间的里程空缺和重叠
+func GetRUCReport(vehicleID primitive.ObjectID, from, to time.Time) (*RUCReport, error) {
+	filter := bson.M{
+		"vehicleID":     vehicleID,
+		"deletedAt":     nil,
+		"time":          bson.M{"$gte": from, "$lte": to},
+		"startDistance": bson.M{"$exists": true},
+		"endDistance":   bson.M{"$exists": true},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: 1}})
+	cursor, err := recordCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	records := []Record{}
+	if err = cursor.All(context.TODO(), &records); err != nil {
+		return nil, err
+	}
+	return newRUCReport(vehicleID, from, to, records), nil
+}
+
+// newRUCReport 按时间顺序比较相邻记录的里程, 记录里程按前一条记录的结束里程累计以免重复计算
+func newRUCReport(vehicleID primitive.ObjectID, from, to time.Time, records []Record) *RUCReport {
+	report := &RUCReport{
+		VehicleID: vehicleID,
+		From:      from,
+		To:        to,
+		Segments:  []RUCSegment{},
+		Flags:     []RUCFlag{},
+	}
+	var prev *RUCSegment
+	for _, r := range records {
+		seg := RUCSegment{
+			RecordID:     r.ID,
+			DriverID:     r.DriverID,
+			StartTime:    r.Time.Add(-r.Duration),
+			EndTime:      r.Time,
+			StartMileAge: *r.StartMileAge,
+			EndMileAge:   *r.EndMileAge,
+			Distance:     *r.EndMileAge - *r.StartMileAge,
+		}
+		report.Segments = append(report.Segments, seg)
+		if prev == nil {
+			report.StartMileAge = &seg.StartMileAge
+			report.EndMileAge = &seg.EndMileAge
+			report.RecordedDistance = math.Max(seg.Distance, 0)
+			prev = &report.Segments[len(report.Segments)-1]
+			continue
+		}
+
+		end := *report.EndMileAge
+		if d, diff := compareOdometer(end, seg.StartMileAge); d != "" {
+			flag := RUCFlag{
+				Discrepancy:  d,
+				AfterRecord:  prev.RecordID,
+				BeforeRecord: seg.RecordID,
+				From:         end,
+				To:           seg.StartMileAge,
+				Distance:     math.Abs(diff),
+			}
+			if d == ODOMETERGAP {
+				report.UnrecordedDistance += flag.Distance
+			} else {
+				// 重叠的部分不超过该记录本身的里程
+				flag.Distance = math.Min(flag.Distance, math.Max(seg.Distance, 0))
+				report.OverlapDistance += flag.Distance
+			}
+			report.Flags = append(report.Flags, flag)
+		}
+		// 只累计超出已知最大里程的部分
+		if seg.EndMileAge > end {
+			report.RecordedDistance += seg.EndMileAge - math.Max(seg.StartMileAge, end)
+			report.EndMileAge = &seg.EndMileAge
+		}
+		prev = &report.Segments[len(report.Segments)-1]
+	}
+	if report.StartMileAge != nil {
+		report.Distance = *report.EndMileAge - *report.StartMileAge
+	}
+	return report
+}
+
+// Project 根据RUC许可的里程范围及车辆最近读数预计许可用完的时间
+func (report *RUCReport) Project(licenceFrom, licenceTo float64) (*RUCProjection, error) {
+	reading, err := GetOdometerReading(report.VehicleID)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		reading = nil
+	case err != nil:
+		return nil, err
+	}
+	return report.project(licenceFrom, licenceTo, reading), nil
+}
+
+// project 车辆没有最近读数时以报告的结束里程为准, 两者都没有时无法预计
+func (report *RUCReport) project(licenceFrom, licenceTo float64, reading *OdometerReading) *RUCProjection {
+	p := &RUCProjection{LicenceFrom: licenceFrom, LicenceTo: licenceTo}
+	switch {
+	case reading != nil:
+		p.Reading, p.ReadingTime = reading.Reading, reading.Time
+	case report.EndMileAge != nil:
+		p.Reading, p.ReadingTime = *report.EndMileAge, report.To
+	default:
+		return nil
+	}
+	p.Remaining = licenceTo - p.Reading
+	if days := report.To.Sub(report.From).Hours() / 24; days > 0 {
+		p.DailyDistance = report.Distance / days
+	}
+	switch {
+	case p.Remaining <= 0:
+		p.ExhaustedAt = &p.ReadingTime
+	case p.DailyDistance > 0:
+		at := p.ReadingTime.Add(time.Duration(p.Remaining / p.DailyDistance * float64(24*time.Hour)))
+		p.ExhaustedAt = &at
+	}
+	report.Projection = p
+	return p
+}
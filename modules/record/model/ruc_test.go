@@ -0,0 +1,125 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// mileRecords 按给定的起止里程构造依次衔接的一小时驾驶记录
+func mileRecords(miles ...[2]float64) []Record {
+	records := []Record{}
+	for i, m := range miles {
+		start, end := m[0], m[1]
+		records = append(records, Record{
+			ID:           primitive.NewObjectID(),
+			Type:         DRIVING,
+			Time:         t0.Add(time.Duration(i+1) * time.Hour),
+			Duration:     time.Hour,
+			StartMileAge: &start,
+			EndMileAge:   &end,
+		})
+	}
+	return records
+}
+
+func TestNewRUCReport(t *testing.T) {
+	tests := []struct {
+		name                           string
+		miles                          [][2]float64
+		distance, recorded, unrecorded float64
+		overlap                        float64
+		flags                          []OdometerDiscrepancy
+	}{
+		{"empty", nil, 0, 0, 0, 0, []OdometerDiscrepancy{}},
+		{"continuous", [][2]float64{{100, 150}, {150, 200}, {200.5, 260}}, 160, 159.5, 0, 0, []OdometerDiscrepancy{}},
+		{"gap", [][2]float64{{100, 150}, {170, 200}}, 100, 80, 20, 0, []OdometerDiscrepancy{ODOMETERGAP}},
+		{"overlap", [][2]float64{{100, 150}, {140, 180}}, 80, 80, 0, 10, []OdometerDiscrepancy{ODOMETERROLLBACK}},
+		{"overlap limited to record", [][2]float64{{100, 150}, {110, 120}, {150, 160}}, 60, 60, 0, 10, []OdometerDiscrepancy{ODOMETERROLLBACK}},
+		{"gap after overlap", [][2]float64{{100, 150}, {110, 120}, {170, 180}}, 80, 60, 20, 10, []OdometerDiscrepancy{ODOMETERROLLBACK, ODOMETERGAP}},
+	}
+	for _, tt := range tests {
+		report := newRUCReport(primitive.NewObjectID(), t0, t0.Add(24*time.Hour), mileRecords(tt.miles...))
+		if report.Distance != tt.distance || report.RecordedDistance != tt.recorded ||
+			report.UnrecordedDistance != tt.unrecorded || report.OverlapDistance != tt.overlap {
+			t.Errorf("%s: distance %v recorded %v unrecorded %v overlap %v, want %v %v %v %v", tt.name,
+				report.Distance, report.RecordedDistance, report.UnrecordedDistance, report.OverlapDistance,
+				tt.distance, tt.recorded, tt.unrecorded, tt.overlap)
+		}
+		if len(report.Segments) != len(tt.miles) {
+			t.Errorf("%s: %d segments, want %d", tt.name, len(report.Segments), len(tt.miles))
+		}
+		if len(report.Flags) != len(tt.flags) {
+			t.Errorf("%s: flags = %+v, want %v", tt.name, report.Flags, tt.flags)
+			continue
+		}
+		for i, d := range tt.flags {
+			if report.Flags[i].Discrepancy != d {
+				t.Errorf("%s: flag %d = %s, want %s", tt.name, i, report.Flags[i].Discrepancy, d)
+			}
+		}
+	}
+}
+
+func TestRUCFlagRecords(t *testing.T) {
+	records := mileRecords([2]float64{100, 150}, [2]float64{170, 200})
+	report := newRUCReport(primitive.NewObjectID(), t0, t0.Add(24*time.Hour), records)
+	if len(report.Flags) != 1 {
+		t.Fatalf("flags = %+v", report.Flags)
+	}
+	f := report.Flags[0]
+	if f.AfterRecord != records[0].ID || f.BeforeRecord != records[1].ID || f.From != 150 || f.To != 170 || f.Distance != 20 {
+		t.Errorf("flag = %+v", f)
+	}
+}
+
+func TestRUCProject(t *testing.T) {
+	readingTime := t0.Add(12 * time.Hour)
+	reading := &OdometerReading{Reading: 1000, Time: readingTime}
+	report := func(miles ...[2]float64) *RUCReport {
+		// 报告期间为10天
+		return newRUCReport(primitive.NewObjectID(), t0, t0.Add(10*24*time.Hour), mileRecords(miles...))
+	}
+
+	tests := []struct {
+		name        string
+		report      *RUCReport
+		reading     *OdometerReading
+		nilResult   bool
+		daily       float64
+		remaining   float64
+		exhaustedAt *time.Time
+	}{
+		{"no reading", report(), nil, true, 0, 0, nil},
+		{"from reading", report([2]float64{0, 500}), reading, false, 50, 1000, timePtr(readingTime.Add(20 * 24 * time.Hour))},
+		{"from report end", report([2]float64{0, 500}), nil, false, 50, 1500, timePtr(t0.Add(40 * 24 * time.Hour))},
+		{"exhausted", report([2]float64{0, 500}), &OdometerReading{Reading: 2100, Time: readingTime}, false, 50, -100, &readingTime},
+		{"not driven", report(), reading, false, 0, 1000, nil},
+	}
+	for _, tt := range tests {
+		p := tt.report.project(0, 2000, tt.reading)
+		if tt.nilResult {
+			if p != nil {
+				t.Errorf("%s: project = %+v, want nil", tt.name, p)
+			}
+			continue
+		}
+		if p.DailyDistance != tt.daily || p.Remaining != tt.remaining {
+			t.Errorf("%s: daily %v remaining %v, want %v %v", tt.name, p.DailyDistance, p.Remaining, tt.daily, tt.remaining)
+		}
+		switch {
+		case tt.exhaustedAt == nil && p.ExhaustedAt != nil:
+			t.Errorf("%s: exhaustedAt = %v, want nil", tt.name, p.ExhaustedAt)
+		case tt.exhaustedAt != nil && (p.ExhaustedAt == nil || !p.ExhaustedAt.Equal(*tt.exhaustedAt)):
+			t.Errorf("%s: exhaustedAt = %v, want %v", tt.name, p.ExhaustedAt, tt.exhaustedAt)
+		}
+		if tt.report.Projection != p {
+			t.Errorf("%s: projection not kept on report", tt.name)
+		}
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
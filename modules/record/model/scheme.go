@@ -0,0 +1,224 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	valid "github.com/asaskevich/govalidator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Scheme 疲劳管理方案, 未分配方案的司机使用默认方案, 运营商可定义经批准的替代方案(AFMS)
+type Scheme struct {
+	ID                  primitive.ObjectID  `bson:"_id" json:"id" valid:"-"`
+	Name                string              `bson:"name" json:"name" valid:"required"`
+	Description         string              `bson:"description,omitempty" json:"description,omitempty" valid:"-"`
+	TransportOperatorID *primitive.ObjectID `bson:"transportOperatorID,omitempty" json:"transportOperatorID,omitempty" valid:"-"`
+	// ContinuousWork 连续工作上限, 需休息至少ContinuousBreak
+	ContinuousWork  HrTime `bson:"continuousWork" json:"continuousWork" valid:"required"`
+	ContinuousBreak HrTime `bson:"continuousBreak" json:"continuousBreak" valid:"required"`
+	// WorkDay 工作日时长, 工作日内工作不得超过DailyWork, 需连续休息至少DailyRest
+	WorkDay   HrTime `bson:"workDay" json:"workDay" valid:"required"`
+	DailyWork HrTime `bson:"dailyWork" json:"dailyWork" valid:"required"`
+	DailyRest HrTime `bson:"dailyRest" json:"dailyRest" valid:"required"`
+	// CumulativeWork 累计工作上限, 需连续休息至少CumulativeRest
+	CumulativeWork HrTime             `bson:"cumulativeWork" json:"cumulativeWork" valid:"required"`
+	CumulativeRest HrTime             `bson:"cumulativeRest" json:"cumulativeRest" valid:"required"`
+	CreatedBy      primitive.ObjectID `bson:"createdBy" json:"createdBy" valid:"-"`
+	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt" valid:"-"`
+}
+
+// DefaultScheme 默认方案, 即新西兰工时规则
+var DefaultScheme = &Scheme{
+	Name:            "default",
+	ContinuousWork:  HR5D5,
+	ContinuousBreak: HR0D5,
+	WorkDay:         HR24,
+	DailyWork:       HR13,
+	DailyRest:       HR10,
+	CumulativeWork:  HR70,
+	CumulativeRest:  HR24,
+}
+
+func (s *Scheme) valid() error {
+	if _, err := valid.ValidateStruct(s); err != nil {
+		return err
+	}
+	switch {
+	case s.ContinuousWork < 0 || s.ContinuousBreak < 0 || s.WorkDay < 0 || s.DailyWork < 0 ||
+		s.DailyRest < 0 || s.CumulativeWork < 0 || s.CumulativeRest < 0:
+		return errors.New("limits should be positive")
+	// 工作日按最长24小时划分记录及汇总
+	case s.WorkDay > HR24:
+		return errors.New("workDay should not exceed 24 hours")
+	case s.DailyWork+s.DailyRest > s.WorkDay:
+		return errors.New("dailyWork and dailyRest should fit in workDay")
+	case s.ContinuousWork > s.DailyWork:
+		return errors.New("continuousWork should not exceed dailyWork")
+	case s.DailyWork > s.CumulativeWork:
+		return errors.New("dailyWork should not exceed cumulativeWork")
+	}
+	return nil
+}
+
+// Add 方案添加到数据库, 方案一经添加不可修改, 以保证历史记录的合规结果不变
+func (s *Scheme) Add() error {
+	if err := s.valid(); err != nil {
+		return err
+	}
+	filter := bson.M{"name": s.Name, "transportOperatorID": s.TransportOperatorID}
+	if count, err := schemeCollection.CountDocuments(context.TODO(), filter); err != nil {
+		return err
+	} else if count > 0 || s.Name == DefaultScheme.Name {
+		return errors.New("scheme name exists")
+	}
+	if _, err := schemeCollection.InsertOne(context.TODO(), s); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetScheme 通过id获取方案, id为空时返回默认方案
+func GetScheme(id primitive.ObjectID) (*Scheme, error) {
+	if id.IsZero() {
+		return DefaultScheme, nil
+	}
+	s := new(Scheme)
+	err := schemeCollection.FindOne(context.TODO(), bson.M{"_id": id}).Decode(s)
+	return s, err
+}
+
+// GetSchemes 获取全部方案, operatorIDs不为nil时只返回通用方案及这些运营商的方案
+func GetSchemes(operatorIDs []primitive.ObjectID) ([]Scheme, error) {
+	filter := bson.M{}
+	if operatorIDs != nil {
+		filter = bson.M{"$or": bson.A{
+			bson.M{"transportOperatorID": nil},
+			bson.M{"transportOperatorID": bson.M{"$in": operatorIDs}},
+		}}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := schemeCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	schemes := []Scheme{}
+	if err = cursor.All(context.TODO(), &schemes); err != nil {
+		return nil, err
+	}
+	return schemes, nil
+}
+
+// SchemeAssignment 司机自From起适用的方案, SchemeID为空表示恢复默认方案, To为下一次分配的开始时间
+type SchemeAssignment struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id" valid:"-"`
+	DriverID  primitive.ObjectID `bson:"driverID" json:"driverID" valid:"required"`
+	SchemeID  primitive.ObjectID `bson:"schemeID" json:"schemeID" valid:"-"`
+	From      time.Time          `bson:"from" json:"from" valid:"required"`
+	To        *time.Time         `bson:"to,omitempty" json:"to,omitempty" valid:"-"`
+	CreatedBy primitive.ObjectID `bson:"createdBy" json:"createdBy" valid:"required"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt" valid:"required"`
+}
+
+// assignmentSkew 分配开始时间允许早于添加时间的误差
+const assignmentSkew = time.Minute
+
+// Add 分配方案, 只能在当前分配之后开始, 并结束当前分配;
+// 不能追溯到过去, 以保证历史记录的合规结果不变
+func (sa *SchemeAssignment) Add() error {
+	if _, err := valid.ValidateStruct(sa); err != nil {
+		return err
+	}
+	if sa.From.Before(sa.CreatedAt.Add(-assignmentSkew)) {
+		return errors.New("assignment cannot start in the past")
+	}
+	if _, err := GetScheme(sa.SchemeID); err != nil {
+		return errors.New("scheme not found")
+	}
+	current := new(SchemeAssignment)
+	opts := options.FindOne().SetSort(bson.D{{Key: "from", Value: -1}})
+	err := schemeAssignmentCollection.FindOne(context.TODO(), bson.M{"driverID": sa.DriverID}, opts).Decode(current)
+	switch {
+	case err == mongo.ErrNoDocuments:
+	case err != nil:
+		return err
+	case !sa.From.After(current.From):
+		return errors.New("assignment should start after the current one")
+	default:
+		update := bson.M{"$set": bson.M{"to": sa.From}}
+		if _, err := schemeAssignmentCollection.UpdateOne(context.TODO(), bson.M{"_id": current.ID}, update); err != nil {
+			return err
+		}
+	}
+	if _, err := schemeAssignmentCollection.InsertOne(context.TODO(), sa); err != nil {
+		return err
+	}
+	// 工作日划分可能随方案改变
+	if now := time.Now(); sa.From.Before(now) {
+		return UpdateSummaries(sa.DriverID, sa.From, now)
+	}
+	return nil
+}
+
+// GetSchemeAssignments 获取司机的全部方案分配, 按开始时间升序排列
+func GetSchemeAssignments(driverID primitive.ObjectID) ([]SchemeAssignment, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "from", Value: 1}})
+	cursor, err := schemeAssignmentCollection.Find(context.TODO(), bson.M{"driverID": driverID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	assignments := []SchemeAssignment{}
+	if err = cursor.All(context.TODO(), &assignments); err != nil {
+		return nil, err
+	}
+	return assignments, nil
+}
+
+// SchemePeriod 自From起适用的方案
+type SchemePeriod struct {
+	From   time.Time
+	Scheme *Scheme
+}
+
+// Schemes 司机适用方案的时间线, 按开始时间升序排列, 第一段之前适用默认方案
+type Schemes []SchemePeriod
+
+// at 获取t时刻适用的方案
+func (ss Schemes) at(t time.Time) *Scheme {
+	i := sort.Search(len(ss), func(i int) bool {
+		return ss[i].From.After(t)
+	})
+	if i == 0 {
+		return DefaultScheme
+	}
+	return ss[i-1].Scheme
+}
+
+// GetDriverSchemes 获取司机在to之前适用方案的时间线
+func GetDriverSchemes(driverID primitive.ObjectID, to time.Time) (Schemes, error) {
+	assignments, err := GetSchemeAssignments(driverID)
+	if err != nil {
+		return nil, err
+	}
+	ss := Schemes{}
+	loaded := make(map[primitive.ObjectID]*Scheme)
+	for _, a := range assignments {
+		if !a.From.Before(to) {
+			break
+		}
+		s, ok := loaded[a.SchemeID]
+		if !ok {
+			if s, err = GetScheme(a.SchemeID); err != nil {
+				return nil, err
+			}
+			loaded[a.SchemeID] = s
+		}
+		ss = append(ss, SchemePeriod{From: a.From, Scheme: s})
+	}
+	return ss, nil
+}
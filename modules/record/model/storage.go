@@ -0,0 +1,119 @@
+package model
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// ArchiveStore 归档文件存储
+type ArchiveStore interface {
+	Put(name string, body io.ReadSeeker) error
+	Get(name string) (io.ReadCloser, error)
+}
+
+// localStore 本地目录存储
+type localStore struct {
+	dir string
+}
+
+// Put 先写入临时文件再重命名, 避免留下不完整的归档
+func (ls *localStore) Put(name string, body io.ReadSeeker) error {
+	path := filepath.Join(ls.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, body); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Get 读取归档文件
+func (ls *localStore) Get(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(ls.dir, filepath.FromSlash(name)))
+}
+
+// s3Store S3兼容的对象存储
+type s3Store struct {
+	client *s3.S3
+	bucket string
+	prefix string
+}
+
+// Put 上传归档文件
+func (ss *s3Store) Put(name string, body io.ReadSeeker) error {
+	_, err := ss.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(ss.prefix + name),
+		Body:   body,
+	})
+	return err
+}
+
+// Get 下载归档文件
+func (ss *s3Store) Get(name string) (io.ReadCloser, error) {
+	out, err := ss.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(ss.bucket),
+		Key:    aws.String(ss.prefix + name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// newArchiveStore 按配置创建归档存储, 未配置时返回nil
+func newArchiveStore() (ArchiveStore, error) {
+	switch config["record.archive.storage"] {
+	case "":
+		return nil, nil
+	case "local":
+		dir := config["record.archive.dir"]
+		if dir == "" {
+			return nil, errors.New("record.archive.dir is required")
+		}
+		return &localStore{dir: dir}, nil
+	case "s3":
+		bucket := config["record.archive.s3.bucket"]
+		if bucket == "" {
+			return nil, errors.New("record.archive.s3.bucket is required")
+		}
+		awsConfig := &aws.Config{
+			Region: aws.String(config["record.archive.s3.region"]),
+			Credentials: credentials.NewStaticCredentials(
+				config["record.archive.s3.accesskeyid"],
+				config["record.archive.s3.secretaccesskey"],
+				"",
+			),
+		}
+		// 非AWS的S3兼容存储需要指定endpoint并使用路径方式访问
+		if endpoint := config["record.archive.s3.endpoint"]; endpoint != "" {
+			awsConfig.Endpoint = aws.String(endpoint)
+			awsConfig.S3ForcePathStyle = aws.Bool(true)
+		}
+		sess, err := session.NewSession(awsConfig)
+		if err != nil {
+			return nil, err
+		}
+		return &s3Store{client: s3.New(sess), bucket: bucket, prefix: config["record.archive.s3.prefix"]}, nil
+	}
+	return nil, errors.New("unsupported archive storage")
+}
@@ -0,0 +1,119 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	locModel "github.com/chadhao/logit/modules/location/model"
+)
+
+// suggestionLookback 上一条记录早于该时长时不再生成建议
+const suggestionLookback = 7 * 24 * time.Hour
+
+// Suggestion 根据行驶位置建议添加的记录, Distance为GPS里程(公里);
+// Extend为true时Record是延长到同类活动结束的上一条记录, 接受时通过Amend修改
+type Suggestion struct {
+	Record   Record  `json:"record"`
+	Distance float64 `json:"distance"`
+	Extend   bool    `json:"extend,omitempty"`
+}
+
+// GetSuggestions 根据上一条记录之后的行驶位置生成依次衔接的建议记录, 只有第一条可以直接添加
+func GetSuggestions(driverID primitive.ObjectID, now time.Time) ([]Suggestion, error) {
+	lastRec, err := GetLastestRecord(driverID)
+	if err == mongo.ErrNoDocuments {
+		return []Suggestion{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if now.Sub(lastRec.Time) > suggestionLookback {
+		return nil, errors.New("last record is too old for suggestions")
+	}
+	movements, err := locModel.GetMovements(driverID, lastRec.Time, now)
+	if err != nil {
+		return nil, err
+	}
+	schemes, err := GetDriverSchemes(driverID, now)
+	if err != nil {
+		return nil, err
+	}
+	return newSuggestions(lastRec, movements, schemes), nil
+}
+
+// newSuggestions 行驶建议为驾驶记录, 停留达到工时制度最短休息时长的建议为休息记录, 其余停留建议为其它工作记录.
+// 上一条记录之后仍在继续的同类活动建议延长上一条记录, 不计入其它类型; 仍在进行的最后一段不生成建议
+func newSuggestions(lastRec *Record, movements []locModel.Movement, ss Schemes) []Suggestion {
+	suggestions := []Suggestion{}
+	start, startLocation, prevType := lastRec.Time, lastRec.EndLocation, lastRec.Type
+	for i := 0; i+1 < len(movements); i++ {
+		m := movements[i]
+		t := DRIVING
+		if !m.Moving {
+			t = OTHERWORK
+			if m.End.Sub(m.Start).Hours() >= ss.at(m.Start).ContinuousBreak.getHrs() {
+				t = REST
+			}
+		}
+		s := Suggestion{
+			Record: Record{
+				DriverID:      lastRec.DriverID,
+				Type:          t,
+				Time:          m.End,
+				Duration:      m.End.Sub(start),
+				StartLocation: startLocation,
+				EndLocation:   Location{Coors: m.EndCoors},
+				VehicleID:     lastRec.VehicleID,
+			},
+			Distance: m.Distance / 1000,
+		}
+		if t == prevType {
+			// 只有上一条记录可以延长, 之后的记录无法交替时不再生成建议
+			if i > 0 {
+				break
+			}
+			s.Record = *lastRec
+			s.Record.Time = m.End
+			s.Record.Duration = lastRec.Duration + m.End.Sub(lastRec.Time)
+			s.Record.EndLocation = Location{Coors: m.EndCoors}
+			s.Extend = true
+		}
+		suggestions = append(suggestions, s)
+		start, startLocation, prevType = m.End, s.Record.EndLocation, t
+	}
+	return suggestions
+}
+
+// AcceptSuggestion 接受结束时间为at的第一条建议, 新记录通过Add添加, 延长上一条记录通过Amend修改, 建议已变化时返回错误
+func AcceptSuggestion(driverID primitive.ObjectID, at time.Time) (*Record, error) {
+	suggestions, err := GetSuggestions(driverID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if len(suggestions) == 0 {
+		return nil, errors.New("no suggestion to accept")
+	}
+	r := &suggestions[0].Record
+	if !r.Time.Equal(at) {
+		return nil, errors.New("suggestion is out of date")
+	}
+	if suggestions[0].Extend {
+		lastRec, err := GetRecord(r.ID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = lastRec.Amend(r, driverID, "extended to match GPS movement"); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	r.ID = primitive.NewObjectID()
+	r.CreatedAt = time.Now()
+	if err = r.Add(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	locModel "github.com/chadhao/logit/modules/location/model"
+)
+
+func TestNewSuggestions(t *testing.T) {
+	h, m := time.Hour, time.Minute
+	// mv 从t0开始的一段行驶或停留
+	mv := func(moving bool, from, to time.Duration, km float64) locModel.Movement {
+		return locModel.Movement{
+			Moving:   moving,
+			Start:    t0.Add(from),
+			End:      t0.Add(to),
+			EndCoors: locModel.Coors{Lat: -37, Lng: 175 + to.Hours()},
+			Distance: km * 1000,
+		}
+	}
+	last := func(typ Type) *Record {
+		return &Record{
+			ID:          primitive.NewObjectID(),
+			DriverID:    primitive.NewObjectID(),
+			Type:        typ,
+			Time:        t0,
+			Duration:    h,
+			EndLocation: Location{Coors: locModel.Coors{Lat: -37, Lng: 175}},
+		}
+	}
+	type suggestion struct {
+		typ      Type
+		end      time.Duration
+		duration time.Duration
+		km       float64
+		extend   bool
+	}
+
+	tests := []struct {
+		name      string
+		last      *Record
+		movements []locModel.Movement
+		want      []suggestion
+	}{
+		{"no movement", last(REST), nil, []suggestion{}},
+		{"only ongoing", last(REST), []locModel.Movement{mv(true, 0, h, 80)}, []suggestion{}},
+		{"drive, break and drive", last(REST), []locModel.Movement{
+			mv(true, 0, 2*h, 150), mv(false, 2*h, 2*h+20*m, 0), mv(true, 2*h+20*m, 3*h, 50), mv(false, 3*h, 3*h+10*m, 0),
+		}, []suggestion{
+			{DRIVING, 2 * h, 2 * h, 150, false},
+			{OTHERWORK, 2*h + 20*m, 20 * m, 0, false},
+			{DRIVING, 3 * h, 40 * m, 50, false},
+		}},
+		{"rest from continuous break", last(REST), []locModel.Movement{
+			mv(true, 0, h, 80), mv(false, h, h+30*m, 0), mv(true, h+30*m, 2*h, 40),
+		}, []suggestion{
+			{DRIVING, h, h, 80, false},
+			{REST, h + 30*m, 30 * m, 0, false},
+		}},
+		{"extend last record", last(DRIVING), []locModel.Movement{
+			mv(true, 0, h, 80), mv(false, h, 2*h, 0), mv(true, 2*h, 3*h, 80),
+		}, []suggestion{
+			{DRIVING, h, 2 * h, 80, true},
+			{REST, 2 * h, h, 0, false},
+		}},
+		{"same type after first", last(DRIVING), []locModel.Movement{
+			mv(true, 0, h, 80), mv(false, h, h+10*m, 0), mv(false, h+10*m, h+20*m, 0), mv(true, h+20*m, 2*h, 80),
+		}, []suggestion{
+			{DRIVING, h, 2 * h, 80, true},
+			{OTHERWORK, h + 10*m, 10 * m, 0, false},
+		}},
+	}
+	for _, tt := range tests {
+		got := newSuggestions(tt.last, tt.movements, nil)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: %d suggestions, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		prevEnd := tt.last.EndLocation
+		for i, s := range got {
+			w := tt.want[i]
+			r := s.Record
+			if r.Type != w.typ || !r.Time.Equal(t0.Add(w.end)) || r.Duration != w.duration || s.Distance != w.km || s.Extend != w.extend {
+				t.Errorf("%s: suggestion %d = %s %v %v %v %v, want %+v", tt.name, i, r.Type, r.Time.Sub(t0), r.Duration, s.Distance, s.Extend, w)
+			}
+			if s.Extend != (r.ID == tt.last.ID) || r.DriverID != tt.last.DriverID {
+				t.Errorf("%s: suggestion %d record identity mismatch", tt.name, i)
+			}
+			if !s.Extend && r.StartLocation != prevEnd {
+				t.Errorf("%s: suggestion %d starts at %v, want %v", tt.name, i, r.StartLocation, prevEnd)
+			}
+			if r.EndLocation.Coors != tt.movements[i].EndCoors {
+				t.Errorf("%s: suggestion %d ends at %v", tt.name, i, r.EndLocation)
+			}
+			prevEnd = r.EndLocation
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SummaryPeriod 汇总周期
+type SummaryPeriod string
+
+const (
+	// DAILY 按本地日汇总
+	DAILY SummaryPeriod = "day"
+	// WEEKLY 按本地周汇总, 每周从周一开始
+	WEEKLY SummaryPeriod = "week"
+	// WORKDAY 按工作日汇总
+	WORKDAY SummaryPeriod = "workday"
+)
+
+// Summary 司机按天、按周或按工作日的工时汇总, 日汇总和周汇总的TimeZone为划分周期所用的时区
+type Summary struct {
+	DriverID    primitive.ObjectID   `bson:"driverID" json:"driverID"`
+	Period      SummaryPeriod        `bson:"period" json:"period"`
+	Start       time.Time            `bson:"start" json:"start"`
+	End         time.Time            `bson:"end" json:"end"`
+	Work        time.Duration        `bson:"work" json:"work"`
+	Driving     time.Duration        `bson:"driving" json:"driving"`
+	OtherWork   time.Duration        `bson:"otherWork" json:"otherWork"`
+	Rest        time.Duration        `bson:"rest" json:"rest"`
+	LongestWork time.Duration        `bson:"longestWork" json:"longestWork"`
+	Distance    float64              `bson:"distance" json:"distance"`
+	VehicleIDs  []primitive.ObjectID `bson:"vehicleIDs" json:"vehicleIDs"`
+	TimeZone    string               `bson:"timeZone,omitempty" json:"timeZone,omitempty"`
+	UpdatedAt   time.Time            `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Valid 是否为支持的汇总周期
+func (sp SummaryPeriod) Valid() bool {
+	return sp == DAILY || sp == WEEKLY || sp == WORKDAY
+}
+
+// bucket 返回t在时区l中所在汇总周期的起止时间
+func (sp SummaryPeriod) bucket(t time.Time, l *time.Location) (time.Time, time.Time) {
+	t = t.In(l)
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, l)
+	if sp == WEEKLY {
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+		return start, start.AddDate(0, 0, 7)
+	}
+	return start, start.AddDate(0, 0, 1)
+}
+
+// overlap 两个时间段重叠的时长
+func overlap(start, end, from, to time.Time) time.Duration {
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// longestWork 时间段内最长的连续工作时长, 少于30分钟的休息不中断连续工作
+func (rs Records) longestWork(from, to time.Time) time.Duration {
+	var longest, current, rest time.Duration
+	for _, p := range rs.timeline() {
+		d := overlap(p.Start, p.End, from, to)
+		if d == 0 {
+			continue
+		}
+		if !p.Work {
+			if rest += d; rest >= HR0D5.duration() {
+				current = 0
+			}
+			continue
+		}
+		rest = 0
+		if current += d; current > longest {
+			longest = current
+		}
+	}
+	return longest
+}
+
+// computeSummary 根据记录计算司机某个汇总周期的汇总
+func computeSummary(driverID primitive.ObjectID, sp SummaryPeriod, start, end time.Time) (*Summary, error) {
+	// 记录时间为结束时间, 多取一天以包含跨越周期结束的记录
+	records, err := GetRecords(driverID, start, end.Add(HR24.duration()), false)
+	if err != nil {
+		return nil, err
+	}
+	s := &Summary{
+		DriverID:   driverID,
+		Period:     sp,
+		Start:      start,
+		End:        end,
+		VehicleIDs: []primitive.ObjectID{},
+		UpdatedAt:  time.Now(),
+	}
+	vehicles := make(map[primitive.ObjectID]bool)
+	for _, r := range records {
+		d := overlap(r.Time.Add(-r.Duration), r.Time, start, end)
+		if d == 0 {
+			continue
+		}
+		switch {
+		case r.Type == DRIVING:
+			s.Driving += d
+		case r.Type.IsWork():
+			s.OtherWork += d
+		default:
+			s.Rest += d
+		}
+		if !vehicles[r.VehicleID] {
+			vehicles[r.VehicleID] = true
+			s.VehicleIDs = append(s.VehicleIDs, r.VehicleID)
+		}
+		// 里程计入记录结束的周期
+		if r.StartMileAge != nil && r.EndMileAge != nil && r.Time.Before(end) {
+			s.Distance += *r.EndMileAge - *r.StartMileAge
+		}
+	}
+	s.Work = s.Driving + s.OtherWork
+	s.LongestWork = Records(records).longestWork(start, end)
+	return s, nil
+}
+
+// UpdateSummaries 按司机本地时区重新计算其在from至to之间涉及的所有日汇总、周汇总及工作日汇总
+func UpdateSummaries(driverID primitive.ObjectID, from, to time.Time) error {
+	l := DriverLocation(driverID)
+	rebuilt, err := ensureSummaryZone(driverID, l)
+	if err != nil {
+		return err
+	}
+	if !rebuilt {
+		if err = updateZonedSummaries(driverID, l, from, to); err != nil {
+			return err
+		}
+	}
+	return updateWorkDaySummaries(driverID, from, to)
+}
+
+// updateZonedSummaries 按时区l重新计算from至to涉及的日汇总和周汇总
+func updateZonedSummaries(driverID primitive.ObjectID, l *time.Location, from, to time.Time) error {
+	for _, sp := range []SummaryPeriod{DAILY, WEEKLY} {
+		start, end := sp.bucket(from, l)
+		for start.Before(to) {
+			s, err := computeSummary(driverID, sp, start, end)
+			if err != nil {
+				return err
+			}
+			s.TimeZone = l.String()
+			filter := bson.M{"driverID": driverID, "period": sp, "start": start}
+			if _, err = summaryCollection.ReplaceOne(context.TODO(), filter, s, options.Replace().SetUpsert(true)); err != nil {
+				return err
+			}
+			start, end = sp.bucket(end, l)
+		}
+	}
+	return nil
+}
+
+// ensureSummaryZone 司机更改时区后, 按旧时区划分的日汇总和周汇总与新的周期重叠,
+// 存在其它时区的汇总时删除该司机全部日汇总和周汇总并按时区l重建, 返回是否已重建
+func ensureSummaryZone(driverID primitive.ObjectID, l *time.Location) (bool, error) {
+	filter := bson.M{
+		"driverID": driverID,
+		"period":   bson.M{"$in": bson.A{DAILY, WEEKLY}},
+		"timeZone": bson.M{"$ne": l.String()},
+	}
+	if count, err := summaryCollection.CountDocuments(context.TODO(), filter); err != nil || count == 0 {
+		return false, err
+	}
+	delete(filter, "timeZone")
+	if _, err := summaryCollection.DeleteMany(context.TODO(), filter); err != nil {
+		return false, err
+	}
+
+	first := new(Record)
+	opts := options.FindOne().SetSort(bson.D{{Key: "time", Value: 1}})
+	err := recordCollection.FindOne(context.TODO(), bson.M{"driverID": driverID, "deletedAt": nil}, opts).Decode(first)
+	if err == mongo.ErrNoDocuments {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	last, err := GetLastestRecord(driverID)
+	if err != nil {
+		return false, err
+	}
+	return true, updateZonedSummaries(driverID, l, first.Time.Add(-first.Duration), last.Time)
+}
+
+// updateWorkDaySummaries 重新计算from至to涉及的工作日汇总, 记录变化可能使工作日起点移动, 因此先删除再写入
+func updateWorkDaySummaries(driverID primitive.ObjectID, from, to time.Time) error {
+	// 工作日最长24小时, 开始于from前24小时之后的工作日才可能受影响; 再向前多取一天以确定工作日起点
+	after := from.Add(-HR24.duration())
+	records, err := GetRecords(driverID, after.Add(-HR24.duration()), to.Add(HR24.duration()), false)
+	if err != nil {
+		return err
+	}
+	ss, err := GetDriverSchemes(driverID, to)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"driverID": driverID, "period": WORKDAY, "start": bson.M{"$gt": after, "$lt": to}}
+	if _, err = summaryCollection.DeleteMany(context.TODO(), filter); err != nil {
+		return err
+	}
+	for _, d := range Records(records).WorkDays(ss) {
+		if !d.Start.After(after) || !d.Start.Before(to) {
+			continue
+		}
+		s, err := computeSummary(driverID, WORKDAY, d.Start, d.End)
+		if err != nil {
+			return err
+		}
+		filter := bson.M{"driverID": driverID, "period": WORKDAY, "start": d.Start}
+		if _, err = summaryCollection.ReplaceOne(context.TODO(), filter, s, options.Replace().SetUpsert(true)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateSummaries 更新记录所在周期的汇总
+func (r *Record) updateSummaries() error {
+	return UpdateSummaries(r.DriverID, r.Time.Add(-r.Duration), r.Time)
+}
+
+// GetSummaries 获取司机时间段内的汇总, 按开始时间升序排列
+func GetSummaries(driverID primitive.ObjectID, sp SummaryPeriod, from, to time.Time) ([]Summary, error) {
+	if sp != WORKDAY {
+		if _, err := ensureSummaryZone(driverID, DriverLocation(driverID)); err != nil {
+			return nil, err
+		}
+	}
+	summaries := []Summary{}
+	filter := bson.M{
+		"driverID": driverID,
+		"period":   sp,
+		"end":      bson.M{"$gt": from},
+		"start":    bson.M{"$lt": to},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "start", Value: 1}})
+	cursor, err := summaryCollection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(context.TODO(), &summaries); err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
@@ -0,0 +1,116 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	valid "github.com/asaskevich/govalidator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TeamTrip 双人驾驶行程, 两名司机共用同一辆车
+type TeamTrip struct {
+	ID        primitive.ObjectID   `bson:"_id" json:"id" valid:"-"`
+	VehicleID primitive.ObjectID   `bson:"vehicleID" json:"vehicleID" valid:"required"`
+	DriverIDs []primitive.ObjectID `bson:"driverIDs" json:"driverIDs" valid:"required"`
+	StartTime time.Time            `bson:"startTime" json:"startTime" valid:"required"`
+	EndTime   *time.Time           `bson:"endTime,omitempty" json:"endTime,omitempty" valid:"-"`
+	CreatedBy primitive.ObjectID   `bson:"createdBy" json:"createdBy" valid:"required"`
+	CreatedAt time.Time            `bson:"createdAt" json:"createdAt" valid:"required"`
+}
+
+// Add 双人驾驶行程添加到数据库
+func (tt *TeamTrip) Add() error {
+	if len(tt.DriverIDs) != 2 || tt.DriverIDs[0] == tt.DriverIDs[1] {
+		return errors.New("team trip requires two different drivers")
+	}
+	if _, err := valid.ValidateStruct(tt); err != nil {
+		return err
+	}
+	if _, err := teamTripCollection.InsertOne(context.TODO(), tt); err != nil {
+		return err
+	}
+	return nil
+}
+
+// End 结束双人驾驶行程
+func (tt *TeamTrip) End(t time.Time) error {
+	switch {
+	case tt.EndTime != nil:
+		return errors.New("team trip has already ended")
+	case t.Before(tt.StartTime):
+		return errors.New("endTime should be after startTime")
+	}
+	update := bson.M{"$set": bson.M{"endTime": t}}
+	if _, err := teamTripCollection.UpdateOne(context.TODO(), bson.M{"_id": tt.ID}, update); err != nil {
+		return err
+	}
+	tt.EndTime = &t
+	return nil
+}
+
+// HasDriver 司机是否属于该行程
+func (tt *TeamTrip) HasDriver(driverID primitive.ObjectID) bool {
+	for _, v := range tt.DriverIDs {
+		if v == driverID {
+			return true
+		}
+	}
+	return false
+}
+
+// covers 记录时段是否在行程时间内
+func (tt *TeamTrip) covers(r *Record) bool {
+	if r.Time.Add(-r.Duration).Before(tt.StartTime.Add(-10 * time.Second)) {
+		return false
+	}
+	return tt.EndTime == nil || !r.Time.After(tt.EndTime.Add(10*time.Second))
+}
+
+// GetTeamTrip 通过id获取双人驾驶行程
+func GetTeamTrip(id primitive.ObjectID) (*TeamTrip, error) {
+	tt := new(TeamTrip)
+	err := teamTripCollection.FindOne(context.TODO(), bson.M{"_id": id}).Decode(tt)
+	return tt, err
+}
+
+// GetTeamTripRecords 获取行程中两名司机的全部记录
+func GetTeamTripRecords(id primitive.ObjectID) ([]Record, error) {
+	records := []Record{}
+	cursor, err := recordCollection.Find(context.TODO(), bson.M{"teamTripID": id, "deletedAt": nil})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(context.TODO(), &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// validTeamTrip 验证双人驾驶记录与行程是否一致
+func (r *Record) validTeamTrip() error {
+	if r.TeamTripID == nil {
+		if r.InMovingVehicle {
+			return errors.New("rest in moving vehicle requires a team trip")
+		}
+		return nil
+	}
+	if r.InMovingVehicle && r.Type.IsWork() {
+		return errors.New("only rest can be taken in moving vehicle")
+	}
+	tt, err := GetTeamTrip(*r.TeamTripID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !tt.HasDriver(r.DriverID):
+		return errors.New("driver not in team trip")
+	case tt.VehicleID != r.VehicleID:
+		return errors.New("vehicle not match team trip")
+	case !tt.covers(r):
+		return errors.New("record out of team trip time")
+	}
+	return nil
+}
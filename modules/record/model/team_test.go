@@ -0,0 +1,48 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestTeamTripCovers(t *testing.T) {
+	end := t0.Add(8 * time.Hour)
+	open := &TeamTrip{StartTime: t0}
+	ended := &TeamTrip{StartTime: t0, EndTime: &end}
+
+	tests := []struct {
+		name     string
+		tt       *TeamTrip
+		time     time.Time
+		duration time.Duration
+		want     bool
+	}{
+		{"inside", ended, t0.Add(3 * time.Hour), time.Hour, true},
+		{"whole trip", ended, end, 8 * time.Hour, true},
+		{"starts before", ended, t0.Add(time.Hour), 2 * time.Hour, false},
+		{"starts within tolerance", ended, t0.Add(time.Hour), time.Hour + 10*time.Second, true},
+		{"ends after", ended, end.Add(time.Minute), time.Hour, false},
+		{"ends within tolerance", ended, end.Add(10 * time.Second), time.Hour, true},
+		{"open trip", open, t0.Add(48 * time.Hour), time.Hour, true},
+		{"before open trip", open, t0, time.Hour, false},
+	}
+	for _, tt := range tests {
+		r := &Record{Time: tt.time, Duration: tt.duration}
+		if got := tt.tt.covers(r); got != tt.want {
+			t.Errorf("%s: covers = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTeamTripHasDriver(t *testing.T) {
+	a, b := primitive.NewObjectID(), primitive.NewObjectID()
+	tt := &TeamTrip{DriverIDs: []primitive.ObjectID{a, b}}
+	if !tt.HasDriver(a) || !tt.HasDriver(b) {
+		t.Error("HasDriver = false for trip driver")
+	}
+	if tt.HasDriver(primitive.NewObjectID()) {
+		t.Error("HasDriver = true for other driver")
+	}
+}
@@ -300,6 +300,28 @@ func ForgetPassword(c echo.Context) error {
 	return c.JSON(http.StatusOK, "ok")
 }
 
+func DriverTimeZoneUpdate(c echo.Context) error {
+	tr := request.DriverTimeZoneRequest{}
+
+	if err := c.Bind(&tr); err != nil {
+		return err
+	}
+
+	uid, _ := c.Get("user").(primitive.ObjectID)
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_DRIVER) {
+		return errors.New("is not driver")
+	}
+
+	tr.Id = uid
+	driver, err := tr.Update()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, driver)
+}
+
 func VehicleCreate(c echo.Context) error {
 	vr := request.VehicleCreateRequest{}
 
@@ -322,6 +344,28 @@ func VehicleCreate(c echo.Context) error {
 	return c.JSON(http.StatusOK, vehicle)
 }
 
+func VehicleRUCLicenceUpdate(c echo.Context) error {
+	vr := request.VehicleRUCLicenceRequest{}
+
+	if err := c.Bind(&vr); err != nil {
+		return err
+	}
+
+	uid, _ := c.Get("user").(primitive.ObjectID)
+	roles := utils.RolesAssert(c.Get("roles"))
+	if !roles.Is(constant.ROLE_DRIVER) {
+		return errors.New("is not driver")
+	}
+
+	vr.DriverId = uid
+	vehicle, err := vr.Update()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, vehicle)
+}
+
 func VehicleDelete(c echo.Context) error {
 
 	vr := struct {
@@ -1 +1,52 @@
 package api
+
+import (
+	"time"
+
+	"github.com/chadhao/logit/modules/user/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FindDriver 系统内部获取司机信息
+func FindDriver(id primitive.ObjectID) (*model.Driver, error) {
+	driver := &model.Driver{Id: id}
+	if err := driver.Find(); err != nil {
+		return nil, err
+	}
+	return driver, nil
+}
+
+// FindVehicle 系统内部获取车辆信息
+func FindVehicle(id primitive.ObjectID) (*model.Vehicle, error) {
+	vehicle := &model.Vehicle{Id: id}
+	if err := vehicle.Find(); err != nil {
+		return nil, err
+	}
+	return vehicle, nil
+}
+
+// DriverLocation 系统内部获取司机设置的时区, 未设置时返回nil
+func DriverLocation(id primitive.ObjectID) (*time.Location, error) {
+	driver, err := FindDriver(id)
+	if err != nil {
+		return nil, err
+	}
+	if driver.TimeZone == "" {
+		return nil, nil
+	}
+	return time.LoadLocation(driver.TimeZone)
+}
+
+// IsOperatorUser 系统内部判断用户是否属于运营商
+func IsOperatorUser(operatorID, userID primitive.ObjectID) bool {
+	to := &model.TransportOperator{Id: operatorID}
+	if err := to.Find(); err != nil {
+		return false
+	}
+	for _, v := range to.UserIds {
+		if v == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -68,3 +68,22 @@ func (d *Driver) Find() error {
 
 	return nil
 }
+
+func (d *Driver) UpdateTimeZone(timeZone string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: d.Id}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "timeZone", Value: timeZone}}}}
+
+	result, err := db.Collection("driver").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount != 1 {
+		return errors.New("Driver not updated")
+	}
+
+	d.TimeZone = timeZone
+	return nil
+}
@@ -35,6 +35,7 @@ type (
 		DateOfBirth          time.Time            `json:"dateOfBirth" bson:"dateOfBirth"`
 		Firstnames           string               `json:"firstnames" bson:"firstnames"`
 		Surname              string               `json:"surname" bson:"surname"`
+		TimeZone             string               `json:"timeZone,omitempty" bson:"timeZone,omitempty"`
 		CreatedAt            time.Time            `json:"createdAt" bson:"createdAt"`
 	}
 
@@ -43,9 +44,16 @@ type (
 		DriverId     primitive.ObjectID `json:"driverId" bson:"driverId"`
 		Registration string             `json:"registration" bson:"registration"`
 		IsDiesel     bool               `json:"isDiesel" bson:"isDiesel"`
+		RUCLicence   *RUCLicence        `json:"rucLicence,omitempty" bson:"rucLicence,omitempty"`
 		CreatedAt    time.Time          `json:"createdAt" bson:"createdAt"`
 	}
 
+	RUCLicence struct {
+		LicenceNumber string  `json:"licenceNumber" bson:"licenceNumber"`
+		From          float64 `json:"from" bson:"from"`
+		To            float64 `json:"to" bson:"to"`
+	}
+
 	TransportOperator struct {
 		Id            primitive.ObjectID   `json:"id" bson:"_id"`
 		UserIds       []primitive.ObjectID `json:"userIds" bson:"userIds"`
@@ -75,6 +75,25 @@ func (v *Vehicle) Find() error {
 	return nil
 }
 
+func (v *Vehicle) UpdateRUCLicence(licence *RUCLicence) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	filter := bson.D{{Key: "_id", Value: v.Id}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "rucLicence", Value: licence}}}}
+
+	result, err := db.Collection("vehicle").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount != 1 {
+		return errors.New("Vehicle not updated")
+	}
+
+	v.RUCLicence = licence
+	return nil
+}
+
 func (v *Vehicle) FindByDriverId() ([]Vehicle, error) {
 	vehicles := []Vehicle{}
 	filter := bson.M{
@@ -25,6 +25,11 @@ type (
 		DateOfBirth   time.Time          `json:"dateOfBirth"`
 		Firstnames    string             `json:"firstnames"`
 		Surname       string             `json:"surname"`
+		TimeZone      string             `json:"timeZone"`
+	}
+	DriverTimeZoneRequest struct {
+		Id       primitive.ObjectID `json:"-"`
+		TimeZone string             `json:"timeZone"`
 	}
 	TransportOperatorRegRequest struct {
 		Id            primitive.ObjectID `json:"id"`
@@ -61,12 +66,18 @@ func (r *UserRegRequest) Reg() (*model.User, error) {
 
 func (r *DriverRegRequest) Reg() (*model.Driver, error) {
 	// Should add Request content validation here
+	if r.TimeZone != "" {
+		if _, err := time.LoadLocation(r.TimeZone); err != nil {
+			return nil, errors.New("invalid time zone")
+		}
+	}
 	d := model.Driver{
 		Id:            r.Id,
 		LicenseNumber: r.LicenseNumber,
 		DateOfBirth:   r.DateOfBirth,
 		Firstnames:    r.Firstnames,
 		Surname:       r.Surname,
+		TimeZone:      r.TimeZone,
 		CreatedAt:     time.Now(),
 	}
 
@@ -100,3 +111,23 @@ func (r *UserUpdateRequest) Replace(user *model.User) (err error) {
 	user.Password = r.Password
 	return nil
 }
+
+func (r *DriverTimeZoneRequest) Update() (*model.Driver, error) {
+	if r.TimeZone == "" {
+		return nil, errors.New("time zone is required")
+	}
+	if _, err := time.LoadLocation(r.TimeZone); err != nil {
+		return nil, errors.New("invalid time zone")
+	}
+
+	d := &model.Driver{Id: r.Id}
+	if err := d.Find(); err != nil {
+		return nil, err
+	}
+	// 记录模块按旧时区划分的日汇总和周汇总在下次读取或更新时按新时区重建
+	if err := d.UpdateTimeZone(r.TimeZone); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
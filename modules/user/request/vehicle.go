@@ -1,6 +1,7 @@
 package request
 
 import (
+	"errors"
 	"time"
 
 	valid "github.com/asaskevich/govalidator"
@@ -15,6 +16,13 @@ type (
 		Registration string             `json:"registration" valid:"numeric,stringlength(5|9)"`
 		IsDiesel     bool               `json:"isDiesel" valid:"required"`
 	}
+	VehicleRUCLicenceRequest struct {
+		Id            primitive.ObjectID `json:"id" valid:"required"`
+		DriverId      primitive.ObjectID `json:"-" valid:"-"`
+		LicenceNumber string             `json:"licenceNumber" valid:"required"`
+		From          float64            `json:"from" valid:"-"`
+		To            float64            `json:"to" valid:"required"`
+	}
 )
 
 func (r *VehicleCreateRequest) Create() (*model.Vehicle, error) {
@@ -32,3 +40,34 @@ func (r *VehicleCreateRequest) Create() (*model.Vehicle, error) {
 
 	return vehicle, vehicle.Create()
 }
+
+func (r *VehicleRUCLicenceRequest) Update() (*model.Vehicle, error) {
+	if _, err := valid.ValidateStruct(r); err != nil {
+		return nil, err
+	}
+	if r.From < 0 || r.To <= r.From {
+		return nil, errors.New("invalid licence distance range")
+	}
+
+	vehicle := &model.Vehicle{Id: r.Id}
+	if err := vehicle.Find(); err != nil {
+		return nil, err
+	}
+	if vehicle.DriverId != r.DriverId {
+		return nil, errors.New("no authorization")
+	}
+	if !vehicle.IsDiesel {
+		return nil, errors.New("only diesel vehicles need RUC licence")
+	}
+
+	licence := &model.RUCLicence{
+		LicenceNumber: r.LicenceNumber,
+		From:          r.From,
+		To:            r.To,
+	}
+	if err := vehicle.UpdateRUCLicence(licence); err != nil {
+		return nil, err
+	}
+
+	return vehicle, nil
+}
@@ -51,6 +51,12 @@ func loadRoutes(r router.Router) {
 		Handler: api.DriverRegister,
 		Roles:   []int{constant.ROLE_USER_DEFAULT},
 	})
+	r.Add(&router.Route{
+		Path:    "/user/driver/timezone",
+		Method:  http.MethodPut,
+		Handler: api.DriverTimeZoneUpdate,
+		Roles:   []int{constant.ROLE_DRIVER},
+	})
 	r.Add(&router.Route{
 		Path:    "/user/code",
 		Method:  http.MethodPost,
@@ -83,6 +89,12 @@ func loadRoutes(r router.Router) {
 		Handler: api.VehicleDelete,
 		Roles:   []int{constant.ROLE_DRIVER},
 	})
+	r.Add(&router.Route{
+		Path:    "/user/vehicle/ruc",
+		Method:  http.MethodPut,
+		Handler: api.VehicleRUCLicenceUpdate,
+		Roles:   []int{constant.ROLE_DRIVER},
+	})
 	r.Add(&router.Route{
 		Path:    "/user/vehicles",
 		Method:  http.MethodGet,